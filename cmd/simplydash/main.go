@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/robert-sandor/simplydash/internal"
 )
@@ -13,30 +17,82 @@ func main() {
 	args := internal.GetArgs()
 	internal.SetupSlog(args)
 
-	slog.LogAttrs(context.Background(), slog.LevelDebug, "loading config", slog.String("configFile", args.ConfigFile))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.LogAttrs(ctx, slog.LevelDebug, "loading config", slog.String("configFile", args.ConfigFile))
 	config, err := internal.GetConfig(args)
 	logErrorAndExit(err, "invalid config")
 
-	healthCheckService := internal.NewHealthcheckService()
+	healthCheckService := internal.NewHealthcheckService(ctx, args.HealthHistory)
 	healthCheckService.Init()
 
 	slog.Debug("initializing app service")
-	appService := internal.NewAppService(config, healthCheckService)
+	appService := internal.NewAppService(ctx, config, healthCheckService)
 	appService.Init()
 
+	slog.Debug("initializing notification service")
+	notificationService := internal.NewNotificationService(ctx, config.Notifications, healthCheckService, appService)
+	notificationService.Init()
+
+	slog.Debug("initializing broadcaster")
+	broadcaster := internal.NewBroadcaster(ctx, appService)
+	broadcaster.Init()
+
 	slog.Debug("initializing websocket server")
-	websocketServer := internal.NewWebsocketServer(appService)
-	websocketServer.Init()
+	websocketServer := internal.NewWebsocketServer(ctx, broadcaster, appService)
 
 	slog.Debug("initializing image service")
-	imageService := internal.NewImageService(args.ImageCacheDir)
+	imageService := internal.NewImageService(ctx, args.ImageCacheDir)
+
+	slog.Debug("initializing auth")
+	auth, err := internal.NewAuth(config.Auth)
+	logErrorAndExit(err, "invalid auth config")
 
 	slog.Debug("initializing echo")
-	echo := internal.CreateEcho(args)
-	internal.SetupRouting(echo, websocketServer, imageService, config)
+	echo := internal.CreateEcho(args, auth)
+	internal.SetupRouting(ctx, echo, appService, broadcaster, websocketServer, imageService, auth)
+
+	slog.Debug("watching config file")
+	err = internal.WatchConfig(ctx, args, func(config internal.Config) {
+		appService.Reload(config)
+		notificationService.Reload(config)
+		auth.Reload(config)
+	})
+	if err != nil {
+		slog.Error("watching config file, changes require a restart", "error", err)
+	}
 
-	err = echo.Start(fmt.Sprintf("%s:%s", args.Host, args.Port))
-	logErrorAndExit(err, "server shut down")
+	serverErrCh := make(chan error, 1)
+	go func() {
+		serverErrCh <- echo.Start(fmt.Sprintf("%s:%s", args.Host, args.Port))
+	}()
+
+	select {
+	case err = <-serverErrCh:
+		logErrorAndExit(err, "server shut down")
+	case <-ctx.Done():
+	}
+
+	// a second signal kills the process right away
+	stop()
+	slog.Info("shutting down", "timeout", args.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), args.ShutdownTimeout)
+	defer cancel()
+
+	err = errors.Join(
+		echo.Shutdown(shutdownCtx),
+		websocketServer.Shutdown(shutdownCtx),
+		appService.Shutdown(shutdownCtx),
+		notificationService.Shutdown(shutdownCtx),
+		healthCheckService.Shutdown(shutdownCtx),
+	)
+	if serverErr := <-serverErrCh; !errors.Is(serverErr, http.ErrServerClosed) {
+		err = errors.Join(err, serverErr)
+	}
+	logErrorAndExit(err, "shutting down")
+	slog.Info("shut down")
 }
 
 func logErrorAndExit(err error, msg string) {
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// setupAPI registers the versioned rest api, documented in openapi.yaml.
+func setupAPI(api *echo.Group, appService AppService) {
+	api.GET("/apps", listApps(appService))
+	api.GET("/apps/:id", getAppById(appService))
+	api.GET("/groups", listGroups(appService))
+	api.GET("/providers", getProviderStatuses(appService))
+	api.GET("/openapi.yaml", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "application/yaml", openAPIDocument)
+	})
+}
+
+// appFilter selects apps by the query parameters of the api. Repeated or comma separated values of a parameter
+// match any of them, and the parameters must all match.
+type appFilter struct {
+	groups []string
+	health []AppHealth
+	tags   []string
+	query  string
+}
+
+func parseAppFilter(params url.Values) (appFilter, error) {
+	filter := appFilter{
+		groups: splitParam(params, "group"),
+		tags:   splitParam(params, "tag"),
+		query:  strings.ToLower(strings.TrimSpace(params.Get("q"))),
+	}
+
+	for _, value := range splitParam(params, "health") {
+		var health AppHealth
+		if err := health.UnmarshalText([]byte(value)); err != nil {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid health %s", value))
+		}
+		filter.health = append(filter.health, health)
+	}
+	return filter, nil
+}
+
+func splitParam(params url.Values, name string) []string {
+	values := make([]string, 0)
+	for _, param := range params[name] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func (f appFilter) empty() bool {
+	return len(f.groups) == 0 && len(f.health) == 0 && len(f.tags) == 0 && f.query == ""
+}
+
+func (f appFilter) matches(app App) bool {
+	if len(f.groups) > 0 && !slices.ContainsFunc(f.groups, equalFold(app.Group)) {
+		return false
+	}
+
+	if len(f.health) > 0 && !slices.Contains(f.health, app.Healthcheck.Health) {
+		return false
+	}
+
+	if len(f.tags) > 0 && !slices.ContainsFunc(app.Tags, func(tag string) bool {
+		return slices.ContainsFunc(f.tags, equalFold(tag))
+	}) {
+		return false
+	}
+
+	if f.query == "" {
+		return true
+	}
+	for _, text := range append([]string{app.Name, app.Description, app.Group, app.Link}, app.Tags...) {
+		if strings.Contains(strings.ToLower(text), f.query) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalFold(a string) func(string) bool {
+	return func(b string) bool {
+		return strings.EqualFold(a, b)
+	}
+}
+
+func listApps(appService AppService) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filter, err := parseAppFilter(c.QueryParams())
+		if err != nil {
+			return err
+		}
+
+		apps := make([]App, 0)
+		for _, group := range appService.GetApps() {
+			for _, app := range group.Apps {
+				if filter.matches(app) {
+					apps = append(apps, app)
+				}
+			}
+		}
+		return c.JSON(http.StatusOK, apps)
+	}
+}
+
+// listGroups leaves out the groups without matching apps, unless there is no filter.
+func listGroups(appService AppService) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		filter, err := parseAppFilter(c.QueryParams())
+		if err != nil {
+			return err
+		}
+
+		groups := make([]AppGroup, 0)
+		for _, group := range appService.GetApps() {
+			filtered := NewAppGroup(group.Name)
+			for _, app := range group.Apps {
+				if filter.matches(app) {
+					filtered.Apps = append(filtered.Apps, app)
+				}
+			}
+
+			if filter.empty() || len(filtered.Apps) > 0 {
+				groups = append(groups, filtered)
+			}
+		}
+		return c.JSON(http.StatusOK, groups)
+	}
+}
+
+func getAppById(appService AppService) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		for _, group := range appService.GetApps() {
+			for _, app := range group.Apps {
+				if app.ID == id {
+					return c.JSON(http.StatusOK, app)
+				}
+			}
+		}
+		return echo.NewHTTPError(http.StatusNotFound, "app not found")
+	}
+}
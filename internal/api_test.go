@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestAPI() *echo.Echo {
+	appService := &fakeAppService{appGroups: []AppGroup{
+		{Name: "Media", Apps: []App{
+			{ID: "1", Name: "Jellyfin", Group: "Media", Description: "movies", Tags: []string{"streaming"},
+				Healthcheck: AppHealthcheck{Health: Healthy}},
+			{ID: "2", Name: "Sonarr", Group: "Media", Description: "series", Tags: []string{"arr", "downloads"},
+				Healthcheck: AppHealthcheck{Health: Error, HealthSummary: HealthSummary{LastError: "got status code 502"}}},
+		}},
+		{Name: "Infra", Apps: []App{
+			{ID: "3", Name: "Router", Group: "Infra", Link: "https://router.home.arpa", Tags: []string{}, Healthcheck: AppHealthcheck{Health: Unknown}},
+		}},
+		{Name: "Empty", Apps: []App{}},
+	}}
+
+	e := echo.New()
+	setupAPI(e.Group("/api/v1"), appService)
+	return e
+}
+
+func requestAPI(e *echo.Echo, target string, response any) int {
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	if response != nil {
+		_ = json.Unmarshal(recorder.Body.Bytes(), response)
+	}
+	return recorder.Code
+}
+
+func TestAPI_listApps(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{"1", "2", "3"}},
+		{query: "?group=media", expected: []string{"1", "2"}},
+		{query: "?group=infra&group=media", expected: []string{"1", "2", "3"}},
+		{query: "?health=error,unknown", expected: []string{"2", "3"}},
+		{query: "?tag=ARR", expected: []string{"2"}},
+		{query: "?q=router.home", expected: []string{"3"}},
+		{query: "?q=stream", expected: []string{"1"}},
+		{query: "?group=media&health=healthy", expected: []string{"1"}},
+		{query: "?tag=missing", expected: []string{}},
+	}
+	e := newTestAPI()
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var apps []App
+			assert.Equal(t, http.StatusOK, requestAPI(e, "/api/v1/apps"+tt.query, &apps))
+
+			ids := make([]string, 0)
+			for _, app := range apps {
+				ids = append(ids, app.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	var response map[string]string
+	assert.Equal(t, http.StatusBadRequest, requestAPI(e, "/api/v1/apps?health=sick", &response))
+	assert.Equal(t, "invalid health sick", response["message"])
+}
+
+func TestAPI_listGroups(t *testing.T) {
+	e := newTestAPI()
+
+	var groups []AppGroup
+	assert.Equal(t, http.StatusOK, requestAPI(e, "/api/v1/groups", &groups))
+	assert.Len(t, groups, 3)
+
+	assert.Equal(t, http.StatusOK, requestAPI(e, "/api/v1/groups?health=error", &groups))
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "Media", groups[0].Name)
+		assert.Len(t, groups[0].Apps, 1)
+	}
+}
+
+func TestAPI_getApp(t *testing.T) {
+	e := newTestAPI()
+
+	var app App
+	assert.Equal(t, http.StatusOK, requestAPI(e, "/api/v1/apps/2", &app))
+	assert.Equal(t, "Sonarr", app.Name)
+	assert.Equal(t, "got status code 502", app.Healthcheck.LastError)
+
+	assert.Equal(t, http.StatusNotFound, requestAPI(e, "/api/v1/apps/4", nil))
+}
+
+func TestAPI_openAPI(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	newTestAPI().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.yaml", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var document struct {
+		Paths map[string]any `yaml:"paths"`
+	}
+	assert.NoError(t, yaml.Unmarshal(recorder.Body.Bytes(), &document))
+	assert.Contains(t, document.Paths, "/apps")
+	assert.Contains(t, document.Paths, "/apps/{id}")
+	assert.Contains(t, document.Paths, "/groups")
+	assert.Contains(t, document.Paths, "/providers")
+}
+
+func Test_appId(t *testing.T) {
+	app := App{Name: "Jellyfin", Group: "Media", Link: "https://jellyfin.home.arpa"}
+	assert.Equal(t, appId("docker-local", app), appId("docker-local", app))
+	assert.Len(t, appId("docker-local", app), 16)
+	assert.NotEqual(t, appId("docker-local", app), appId("docker-remote", app))
+}
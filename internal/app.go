@@ -1,28 +1,72 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 )
 
 type App struct {
+	// ID identifies the app in the api, derived from its provider, group, name and link.
+	ID          string         `json:"id"`
 	Name        string         `json:"name"`
 	Link        string         `json:"link"`
 	Group       string         `json:"group"`
 	Description string         `json:"description"`
 	Icon        string         `json:"icon"`
 	Healthcheck AppHealthcheck `json:"healthcheck"`
+	Tags        []string       `json:"tags"`
+	// Provider is the id of the provider of the app, filled in by the app service.
+	Provider string `json:"provider"`
+	// Stale apps come from a remote source that can't be reached at the moment.
+	Stale bool `json:"stale"`
+	// Discovered apps are inferred from other sources (e.g. traefik routers). They take the name, group and icon
+	// of an explicitly configured app with the same link, which is not shown on its own.
+	Discovered bool `json:"-"`
 }
 
 type AppHealthcheck struct {
-	Enabled  bool          `json:"enabled"`
-	Health   AppHealth     `json:"health"`
-	Interval time.Duration `json:"poll_interval"`
-	Timeout  time.Duration `json:"timeout"`
+	// HealthSummary is filled in from the history of the health check, when it has a checker.
+	HealthSummary
+	// Headers are sent with http checks. They often carry credentials, so they are never serialized.
+	Headers map[string]string `json:"-"`
+	// Type selects the checker, one of http (default), tcp, dns or tls.
+	Type string `json:"type"`
+	// Source selects where the health comes from: the checker (http), the provider (docker), or the worse of both.
+	Source string `json:"source"`
+	// Link is the target of the health check, defaulting to the link of the app.
+	// It is a url for http checks, and an address (host:port) for tcp, dns and tls checks.
+	// Like the other internal targets below, it may point at internal hosts, so it is never serialized.
+	Link   string    `json:"-"`
+	Method string    `json:"method"`
+	Health AppHealth `json:"health"`
+	// StatusCodes lists the accepted status codes and ranges, like "200-299,401".
+	StatusCodes string `json:"status_codes"`
+	BodyRegex   string `json:"-"`
+	// JSONPath must exist in the json body, and be equal to JSONValue if it is set.
+	JSONPath  string `json:"-"`
+	JSONValue string `json:"-"`
+	// DNSName is the name resolved by dns checks.
+	DNSName string `json:"-"`
+	// CertExpiryDays is how many days before the certificate expires tls checks start warning.
+	CertExpiryDays int `json:"cert_expiry_days"`
+	// Retries are the extra attempts within a single check before it fails.
+	Retries int `json:"retries"`
+	// FailureThreshold is how many consecutive failed checks it takes to go down,
+	// SuccessThreshold how many successful ones to go up.
+	FailureThreshold int           `json:"failure_threshold"`
+	SuccessThreshold int           `json:"success_threshold"`
+	Interval         time.Duration `json:"poll_interval"`
+	Timeout          time.Duration `json:"timeout"`
+	MaxLatency       time.Duration `json:"max_latency"`
+	Enabled          bool          `json:"enabled"`
+	FollowRedirects  bool          `json:"follow_redirects"`
 }
 
 type AppHealth uint32
@@ -39,11 +83,37 @@ func (a AppHealth) MarshalText() ([]byte, error) {
 		return []byte("error"), nil
 	case Unknown:
 		return []byte("unknown"), nil
+	case Degraded:
+		return []byte("degraded"), nil
+	case Pending:
+		return []byte("pending"), nil
 	}
 
 	return nil, fmt.Errorf("invalid value %d", a)
 }
 
+func (a *AppHealth) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "healthy":
+		*a = Healthy
+	case "timeout":
+		*a = Timeout
+	case "warning":
+		*a = Warning
+	case "error":
+		*a = Error
+	case "unknown":
+		*a = Unknown
+	case "degraded":
+		*a = Degraded
+	case "pending":
+		*a = Pending
+	default:
+		return fmt.Errorf("invalid value %s", text)
+	}
+	return nil
+}
+
 func (a AppHealth) String() string {
 	if bytes, err := a.MarshalText(); err == nil {
 		return string(bytes)
@@ -57,8 +127,36 @@ const (
 	Warning
 	Error
 	Unknown
+	// Degraded is reported while failures of a healthy app are being confirmed.
+	Degraded
+	// Pending is reported while a transition from an unknown or failing state is being confirmed.
+	Pending
+)
+
+const (
+	HealthSourceHTTP   = "http"
+	HealthSourceDocker = "docker"
+	HealthSourceBoth   = "both"
 )
 
+// healthSeverity orders the health values from the best to the worst.
+var healthSeverity = map[AppHealth]int{
+	Healthy:  0,
+	Unknown:  1,
+	Pending:  2,
+	Warning:  3,
+	Degraded: 4,
+	Timeout:  5,
+	Error:    6,
+}
+
+func worseHealth(a AppHealth, b AppHealth) AppHealth {
+	if healthSeverity[b] > healthSeverity[a] {
+		return b
+	}
+	return a
+}
+
 type AppGroup struct {
 	Name string `json:"name"`
 	Apps []App  `json:"apps"`
@@ -94,10 +192,24 @@ func (app *App) Validate() (errs []error) {
 	}
 	app.resolveIconUrl()
 
+	if app.Tags == nil {
+		app.Tags = []string{}
+	}
+
+	if strings.TrimSpace(app.Healthcheck.Link) == "" {
+		app.Healthcheck.Link = app.Link
+	}
+
 	errs = append(errs, app.Healthcheck.Validate()...)
 	return
 }
 
+// appId is stable across restarts and reloads, as long as the app keeps its provider, group, name and link.
+func appId(providerId string, app App) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{providerId, app.Group, app.Name, app.Link}, "\x00")))
+	return hex.EncodeToString(hash[:8])
+}
+
 func (app *App) resolveIconUrl() {
 	if _, err := url.ParseRequestURI(app.Icon); err == nil {
 		return
@@ -122,5 +234,53 @@ func (appHealth *AppHealthcheck) Validate() (errs []error) {
 		appHealth.Timeout = 30 * time.Second
 	}
 
+	appHealth.Type = strings.ToLower(strings.TrimSpace(appHealth.Type))
+	if appHealth.Type == "" {
+		appHealth.Type = DefaultHealthcheckType
+	}
+
+	appHealth.Source = strings.ToLower(strings.TrimSpace(appHealth.Source))
+	if appHealth.Source == "" {
+		appHealth.Source = DefaultHealthcheckSource
+	}
+
+	if !slices.Contains([]string{HealthSourceHTTP, HealthSourceDocker, HealthSourceBoth}, appHealth.Source) {
+		errs = append(errs, fmt.Errorf("unsupported healthcheck source %s", appHealth.Source))
+	}
+
+	appHealth.Method = strings.ToUpper(strings.TrimSpace(appHealth.Method))
+	if appHealth.Method == "" {
+		appHealth.Method = DefaultHealthcheckMethod
+	}
+
+	if appHealth.Retries < 0 {
+		appHealth.Retries = 0
+	}
+
+	if appHealth.FailureThreshold <= 0 {
+		appHealth.FailureThreshold = DefaultHealthcheckFailureThreshold
+	}
+
+	if appHealth.SuccessThreshold <= 0 {
+		appHealth.SuccessThreshold = DefaultHealthcheckSuccessThreshold
+	}
+
+	if appHealth.CertExpiryDays <= 0 {
+		appHealth.CertExpiryDays = DefaultHealthcheckCertExpiryDays
+	}
+
+	if !appHealth.probed() {
+		return
+	}
+
+	if _, err := newChecker(*appHealth); err != nil {
+		errs = append(errs, err)
+	}
+
 	return
 }
+
+// probed tells whether the health check needs a checker, rather than relying on the provider only.
+func (appHealth *AppHealthcheck) probed() bool {
+	return appHealth.Enabled && appHealth.Source != HealthSourceDocker
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"reflect"
+	"slices"
+)
+
+const (
+	deltaAppAdded      = "app_added"
+	deltaAppRemoved    = "app_removed"
+	deltaAppUpdated    = "app_updated"
+	deltaHealthChanged = "health_changed"
+)
+
+// AppDelta is a single change between two lists of app groups. Apps are found by id within their group,
+// and added apps are inserted at Index in their group.
+type AppDelta struct {
+	App         *App            `json:"app,omitempty"`
+	Healthcheck *AppHealthcheck `json:"healthcheck,omitempty"`
+	Type        string          `json:"-"`
+	ID          string          `json:"id"`
+	Group       string          `json:"group"`
+	Index       int             `json:"index"`
+}
+
+// diffAppGroups returns the deltas turning previous into current: removals first, then updates, then additions
+// in the order of current, so that applying them in order puts every app at its place. It returns false when
+// the groups themselves changed, which deltas can't express.
+func diffAppGroups(previous, current []AppGroup) ([]AppDelta, bool) {
+	if !sameGroupNames(previous, current) {
+		return nil, false
+	}
+
+	previousById := make(map[string]App)
+	for _, group := range previous {
+		for _, app := range group.Apps {
+			previousById[app.ID] = app
+		}
+	}
+
+	currentIds := make(map[string]bool)
+	for _, group := range current {
+		for _, app := range group.Apps {
+			currentIds[app.ID] = true
+		}
+	}
+
+	deltas := make([]AppDelta, 0)
+	for _, group := range previous {
+		for _, app := range group.Apps {
+			if !currentIds[app.ID] {
+				deltas = append(deltas, AppDelta{Type: deltaAppRemoved, ID: app.ID, Group: app.Group})
+			}
+		}
+	}
+
+	for _, group := range current {
+		for _, app := range group.Apps {
+			previousApp, ok := previousById[app.ID]
+			if !ok || reflect.DeepEqual(previousApp, app) {
+				continue
+			}
+
+			if sameAppExceptHealthcheck(previousApp, app) {
+				deltas = append(deltas, AppDelta{Type: deltaHealthChanged, ID: app.ID, Group: app.Group, Healthcheck: &app.Healthcheck})
+			} else {
+				deltas = append(deltas, AppDelta{Type: deltaAppUpdated, ID: app.ID, Group: app.Group, App: &app})
+			}
+		}
+	}
+
+	for _, group := range current {
+		for index, app := range group.Apps {
+			if _, ok := previousById[app.ID]; !ok {
+				deltas = append(deltas, AppDelta{Type: deltaAppAdded, ID: app.ID, Group: app.Group, Index: index, App: &app})
+			}
+		}
+	}
+
+	return deltas, true
+}
+
+func sameGroupNames(previous, current []AppGroup) bool {
+	if len(previous) != len(current) {
+		return false
+	}
+
+	for _, group := range current {
+		if !slices.ContainsFunc(previous, func(previousGroup AppGroup) bool { return previousGroup.Name == group.Name }) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameAppExceptHealthcheck(a, b App) bool {
+	a.Healthcheck = AppHealthcheck{}
+	b.Healthcheck = AppHealthcheck{}
+	return reflect.DeepEqual(a, b)
+}
+
+// applyAppDelta applies a delta received from a remote instance. It returns false when the delta doesn't match
+// the app groups, meaning that a delta was missed and a new snapshot is needed.
+func applyAppDelta(appGroups []AppGroup, delta AppDelta) bool {
+	groupIndex := slices.IndexFunc(appGroups, func(group AppGroup) bool { return group.Name == delta.Group })
+	if groupIndex < 0 {
+		return false
+	}
+
+	group := &appGroups[groupIndex]
+	appIndex := slices.IndexFunc(group.Apps, func(app App) bool { return app.ID == delta.ID })
+
+	switch delta.Type {
+	case deltaAppAdded:
+		if appIndex >= 0 || delta.App == nil {
+			return false
+		}
+		group.Apps = slices.Insert(group.Apps, min(delta.Index, len(group.Apps)), *delta.App)
+	case deltaAppRemoved:
+		if appIndex < 0 {
+			return false
+		}
+		group.Apps = slices.Delete(group.Apps, appIndex, appIndex+1)
+	case deltaAppUpdated:
+		if appIndex < 0 || delta.App == nil {
+			return false
+		}
+		group.Apps[appIndex] = *delta.App
+	case deltaHealthChanged:
+		if appIndex < 0 || delta.Healthcheck == nil {
+			return false
+		}
+		group.Apps[appIndex].Healthcheck = *delta.Healthcheck
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func deltaTestApp(name, group string, health AppHealth) App {
+	app := App{Name: name, Link: "https://" + name, Group: group, Tags: []string{}}
+	app.ID = appId("file-test", app)
+	app.Healthcheck.Health = health
+	return app
+}
+
+func deltaTestGroups(apps ...App) []AppGroup {
+	groups := []AppGroup{NewAppGroup("a"), NewAppGroup("b")}
+	for _, app := range apps {
+		for i := range groups {
+			if groups[i].Name == app.Group {
+				groups[i].Apps = insertOrdered(groups[i].Apps, app)
+			}
+		}
+	}
+	return groups
+}
+
+func TestDiffAppGroups(t *testing.T) {
+	one := deltaTestApp("one", "a", Healthy)
+	two := deltaTestApp("two", "a", Healthy)
+	three := deltaTestApp("three", "b", Healthy)
+
+	downTwo := two
+	downTwo.Healthcheck.Health = Error
+	describedThree := three
+	describedThree.Description = "described"
+	zero := deltaTestApp("zero", "a", Unknown)
+
+	previous := deltaTestGroups(one, two, three)
+	current := deltaTestGroups(downTwo, describedThree, zero)
+
+	deltas, ok := diffAppGroups(previous, current)
+	assert.True(t, ok)
+	types := make([]string, 0)
+	for _, delta := range deltas {
+		types = append(types, delta.Type)
+	}
+	assert.Equal(t, []string{deltaAppRemoved, deltaHealthChanged, deltaAppUpdated, deltaAppAdded}, types)
+	assert.Equal(t, one.ID, deltas[0].ID)
+	assert.Equal(t, Error, deltas[1].Healthcheck.Health)
+	assert.Nil(t, deltas[1].App)
+	assert.Equal(t, 1, deltas[3].Index)
+
+	for _, delta := range deltas {
+		assert.True(t, applyAppDelta(previous, delta), delta.Type)
+	}
+	assert.Equal(t, current, previous, "applying the deltas gives the current app groups")
+
+	unchanged, ok := diffAppGroups(current, current)
+	assert.True(t, ok)
+	assert.Empty(t, unchanged)
+
+	_, ok = diffAppGroups(current, current[:1])
+	assert.False(t, ok, "removed groups need a snapshot")
+}
+
+func TestApplyAppDelta_missedDeltas(t *testing.T) {
+	one := deltaTestApp("one", "a", Healthy)
+	groups := deltaTestGroups(one)
+
+	assert.False(t, applyAppDelta(groups, AppDelta{Type: deltaAppRemoved, ID: "unknown", Group: "a"}))
+	assert.False(t, applyAppDelta(groups, AppDelta{Type: deltaAppAdded, ID: one.ID, Group: "a", App: &one}))
+	assert.False(t, applyAppDelta(groups, AppDelta{Type: deltaAppUpdated, ID: one.ID, Group: "unknown", App: &one}))
+}
@@ -1,133 +1,390 @@
 package internal
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
 )
 
 type AppService interface {
 	Init()
 	GetApps() []AppGroup
+	GetSettings() AppConfig
+	GetProviderStatuses() []ProviderStatus
+	Reload(config Config)
+	// Recheck runs the health check of an app right away.
+	Recheck(appId string) error
+	// Resync asks a provider to sync right away.
+	Resync(providerId string) error
 	UpdateCh() <-chan struct{}
+	// Shutdown waits for the service to stop after its context is cancelled, then stops all providers.
+	Shutdown(ctx context.Context) error
 }
 
-func NewAppService(config Config, healthCheckService HealthcheckService) AppService {
+func NewAppService(ctx context.Context, config Config, healthCheckService HealthcheckService) AppService {
 	providerUpdateCh := make(chan string, 1)
-	providers := BuildProviders(config, providerUpdateCh)
+	providers := make(map[string]*providerSupervisor)
+	for id, provider := range BuildProviders(ctx, config, providerUpdateCh) {
+		providers[id] = newProviderSupervisor(ctx, provider)
+	}
 
-	return &appServiceImpl{
+	svc := &appServiceImpl{
+		ctx:                ctx,
+		doneCh:             make(chan struct{}),
 		config:             config,
 		healthCheckService: healthCheckService,
 		appsByProviderId:   make(map[string][]App),
 		providers:          providers,
 		providerUpdateCh:   providerUpdateCh,
+		reloadCh:           make(chan Config),
 		updateCh:           make(chan struct{}, 1),
 		logger:             slog.With("name", "app-service"),
 	}
+	svc.publish()
+	return svc
 }
 
+// appServiceImpl is owned by the goroutine running listen: only it reads and writes the providers,
+// their apps and the config. Readers get immutable snapshots, published after every change.
 type appServiceImpl struct {
+	ctx                context.Context
 	healthCheckService HealthcheckService
 	appsByProviderId   map[string][]App
-	providers          map[string]Provider
-	providerUpdateCh   <-chan string
+	providers          map[string]*providerSupervisor
+	providerUpdateCh   chan string
+	reloadCh           chan Config
 	updateCh           chan struct{}
+	doneCh             chan struct{}
 	logger             *slog.Logger
+	snapshot           atomic.Pointer[appSnapshot]
 	config             Config
 }
 
+// appSnapshot must not be modified once published.
+type appSnapshot struct {
+	providers     map[string]*providerSupervisor
+	appsCountById map[string]int
+	appGroups     []AppGroup
+	settings      AppConfig
+}
+
 func (svc *appServiceImpl) GetApps() []AppGroup {
-	indexByGroupName := make(map[string]int)
-	appGroups := make([]AppGroup, 0)
+	return svc.snapshot.Load().appGroups
+}
 
-	for _, groupName := range svc.config.App.Groups {
-		indexByGroupName[groupName] = len(appGroups)
-		appGroups = append(appGroups, NewAppGroup(groupName))
-	}
+func (svc *appServiceImpl) GetSettings() AppConfig {
+	return svc.snapshot.Load().settings
+}
 
-	for _, providerApps := range svc.appsByProviderId {
-		for _, providerApp := range providerApps {
-			var index int
-			index, ok := indexByGroupName[providerApp.Group]
+func (svc *appServiceImpl) GetProviderStatuses() []ProviderStatus {
+	snapshot := svc.snapshot.Load()
 
-			if !ok {
-				index = len(appGroups)
-				indexByGroupName[providerApp.Group] = index
-				appGroups = append(appGroups, NewAppGroup(providerApp.Group))
-			}
+	statuses := make([]ProviderStatus, 0, len(snapshot.providers))
+	for id, supervisor := range snapshot.providers {
+		status := supervisor.status()
+		status.Apps = snapshot.appsCountById[id]
+		statuses = append(statuses, status)
+	}
 
-			if providerApp.Healthcheck.Enabled {
-				providerApp.Healthcheck.Health = svc.healthCheckService.Get(providerApp.Link)
-			}
+	slices.SortFunc(statuses, func(a, b ProviderStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return statuses
+}
 
-			appGroups[index].Apps = insertOrdered(appGroups[index].Apps, providerApp)
-		}
+func (svc *appServiceImpl) Init() {
+	for id, supervisor := range svc.providers {
+		svc.startProvider(id, supervisor)
 	}
+	go svc.listen()
+}
 
-	return appGroups
+// Reload applies a new config: providers which were removed or whose config changed are stopped,
+// new or changed ones are started, and clients are notified of the new app settings.
+func (svc *appServiceImpl) Reload(config Config) {
+	select {
+	case svc.reloadCh <- config:
+	case <-svc.ctx.Done():
+	}
 }
 
-func (svc *appServiceImpl) Init() {
-	for _, provider := range svc.providers {
-		err := provider.Init()
-		if err != nil {
-			svc.logger.Error("initializing provider", "error", err, "providerId", provider.ID())
-			continue
+func (svc *appServiceImpl) Recheck(appId string) error {
+	for _, group := range svc.GetApps() {
+		for _, app := range group.Apps {
+			if app.ID != appId {
+				continue
+			}
+
+			if !app.Healthcheck.probed() {
+				return fmt.Errorf("app %s has no health check", app.Name)
+			}
+			return svc.healthCheckService.Check(app.ID)
 		}
+	}
+	return fmt.Errorf("unknown app %s", appId)
+}
 
-		go svc.updateApps(provider.ID())
+func (svc *appServiceImpl) Resync(providerId string) error {
+	supervisor, ok := svc.snapshot.Load().providers[providerId]
+	if !ok {
+		return fmt.Errorf("unknown provider %s", providerId)
 	}
-	go svc.listen()
+
+	supervisor.provider.Resync()
+	return nil
 }
 
 func (svc *appServiceImpl) UpdateCh() <-chan struct{} {
 	return svc.updateCh
 }
 
+func (svc *appServiceImpl) Shutdown(ctx context.Context) error {
+	select {
+	case <-svc.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	errs := make(chan error, len(svc.providers))
+	for id, supervisor := range svc.providers {
+		go func() {
+			svc.logger.Info("stopping provider", "providerId", id)
+			err := supervisor.stop(ctx)
+			if err != nil {
+				err = fmt.Errorf("stopping provider %s: %w", id, err)
+			}
+			errs <- err
+		}()
+	}
+
+	var err error
+	for range svc.providers {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
 func (svc *appServiceImpl) listen() {
+	defer close(svc.doneCh)
+
 	for {
 		select {
+		case <-svc.ctx.Done():
+			svc.logger.Debug("stopping")
+			return
 		case providerId := <-svc.providerUpdateCh:
 			svc.logger.Debug("got update from provider", "providerId", providerId)
-			go svc.updateApps(providerId)
+			svc.updateApps(providerId)
 		case <-svc.healthCheckService.Updates():
 			svc.logger.Debug("got update from healthcheck")
-			go svc.notify()
+			svc.publish()
+		case config := <-svc.reloadCh:
+			svc.reload(config)
 		}
 	}
 }
 
-func (svc *appServiceImpl) updateApps(id string) {
-	svc.appsByProviderId[id] = svc.providers[id].Apps()
+func (svc *appServiceImpl) startProvider(id string, supervisor *providerSupervisor) {
+	// a started provider may already have apps, without having notified about them
+	supervisor.start(func() { notifyUpdate(svc.ctx, svc.providerUpdateCh, id) })
+}
 
+func (svc *appServiceImpl) stopProvider(id string, supervisor *providerSupervisor) {
+	svc.logger.Info("stopping provider", "providerId", id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultProviderStopTimeout)
+	defer cancel()
+
+	err := supervisor.stop(ctx)
+	if err != nil {
+		svc.logger.Error("stopping provider", "providerId", id, "error", err)
+	}
+}
+
+func (svc *appServiceImpl) reload(config Config) {
+	if reflect.DeepEqual(svc.config, config) {
+		return
+	}
+
+	oldConfigs := ProviderConfigs(svc.config)
+	newConfigs := ProviderConfigs(config)
+
+	for id, supervisor := range svc.providers {
+		newConfig, ok := newConfigs[id]
+		if ok && reflect.DeepEqual(oldConfigs[id], newConfig) {
+			continue
+		}
+
+		delete(svc.providers, id)
+		delete(svc.appsByProviderId, id)
+		go svc.stopProvider(id, supervisor)
+	}
+
+	for id, provider := range BuildProviders(svc.ctx, config, svc.providerUpdateCh) {
+		if _, ok := svc.providers[id]; ok {
+			continue
+		}
+
+		svc.logger.Info("starting provider", "providerId", id)
+		svc.providers[id] = newProviderSupervisor(svc.ctx, provider)
+		svc.startProvider(id, svc.providers[id])
+	}
+
+	svc.config = config
 	svc.refreshHealthCheckers()
+	svc.publish()
+}
+
+func (svc *appServiceImpl) updateApps(id string) {
+	supervisor, ok := svc.providers[id]
+	if !ok {
+		// the provider was stopped by a reload in the meantime
+		return
+	}
+	svc.appsByProviderId[id] = supervisor.provider.Apps()
 
-	svc.notify()
+	svc.refreshHealthCheckers()
+	svc.publish()
 }
 
-func (svc *appServiceImpl) notify() {
+// publish builds a new snapshot and notifies about it. Notifications are coalesced,
+// as readers only ever need the latest snapshot.
+func (svc *appServiceImpl) publish() {
+	providers := make(map[string]*providerSupervisor, len(svc.providers))
+	appsCountById := make(map[string]int, len(svc.providers))
+	for id, supervisor := range svc.providers {
+		providers[id] = supervisor
+		appsCountById[id] = len(svc.appsByProviderId[id])
+	}
+
+	svc.snapshot.Store(&appSnapshot{
+		providers:     providers,
+		appsCountById: appsCountById,
+		appGroups:     svc.buildAppGroups(),
+		settings:      svc.config.App,
+	})
+
 	svc.logger.Debug("sending update notification")
-	svc.updateCh <- struct{}{}
+	select {
+	case svc.updateCh <- struct{}{}:
+	default:
+	}
 }
 
-func (svc *appServiceImpl) refreshHealthCheckers() {
-	newUrls := make(map[string]AppHealthcheck)
-	for _, apps := range svc.appsByProviderId {
-		for _, app := range apps {
-			if app.Healthcheck.Enabled {
-				newUrls[app.Link] = app.Healthcheck
+func (svc *appServiceImpl) buildAppGroups() []AppGroup {
+	indexByGroupName := make(map[string]int)
+	appGroups := make([]AppGroup, 0)
+
+	for _, groupName := range svc.config.App.Groups {
+		indexByGroupName[groupName] = len(appGroups)
+		appGroups = append(appGroups, NewAppGroup(groupName))
+	}
+
+	for _, app := range svc.visibleApps() {
+		var index int
+		index, ok := indexByGroupName[app.Group]
+
+		if !ok {
+			index = len(appGroups)
+			indexByGroupName[app.Group] = index
+			appGroups = append(appGroups, NewAppGroup(app.Group))
+		}
+
+		app.Healthcheck.Health = svc.health(app.ID, app.Healthcheck)
+		if app.Healthcheck.probed() {
+			app.Healthcheck.HealthSummary = svc.healthCheckService.Summary(app.ID)
+		}
+
+		appGroups[index].Apps = insertOrdered(appGroups[index].Apps, app)
+	}
+
+	return appGroups
+}
+
+// visibleApps returns the apps of all providers, with their provider and id filled in.
+// A discovered app takes the name, group and icon of the configured app with the same link, which is hidden instead.
+func (svc *appServiceImpl) visibleApps() []App {
+	overrides := make(map[string]App)
+	for id, providerApps := range svc.appsByProviderId {
+		for _, providerApp := range providerApps {
+			if providerApp.Discovered {
+				continue
+			}
+			providerApp.Provider = id
+
+			// the same app is picked whatever the order of the providers
+			link := normalizeLink(providerApp.Link)
+			if override, ok := overrides[link]; !ok || providerApp.Provider+providerApp.Name < override.Provider+override.Name {
+				overrides[link] = providerApp
+			}
+		}
+	}
+
+	discoveredLinks := make(map[string]bool)
+	for _, providerApps := range svc.appsByProviderId {
+		for _, providerApp := range providerApps {
+			if providerApp.Discovered {
+				discoveredLinks[normalizeLink(providerApp.Link)] = true
+			}
+		}
+	}
+
+	apps := make([]App, 0)
+	for id, providerApps := range svc.appsByProviderId {
+		for _, providerApp := range providerApps {
+			link := normalizeLink(providerApp.Link)
+			if providerApp.Discovered {
+				if override, ok := overrides[link]; ok {
+					providerApp.Name = override.Name
+					providerApp.Group = override.Group
+					providerApp.Icon = override.Icon
+				}
+			} else if discoveredLinks[link] {
+				continue
 			}
+
+			providerApp.Provider = id
+			providerApp.ID = appId(id, providerApp)
+			apps = append(apps, providerApp)
 		}
 	}
+	return apps
+}
+
+// health combines the health reported by the provider with the health from the checker, depending on the source.
+func (svc *appServiceImpl) health(appId string, healthcheck AppHealthcheck) AppHealth {
+	if !healthcheck.probed() {
+		return healthcheck.Health
+	}
+
+	checked := svc.healthCheckService.Get(appId)
+	if healthcheck.Source == HealthSourceBoth {
+		return worseHealth(healthcheck.Health, checked)
+	}
+	return checked
+}
 
-	existingUrls := svc.healthCheckService.Urls()
+// refreshHealthCheckers runs a checker per app, as apps probing the same url may check it differently.
+func (svc *appServiceImpl) refreshHealthCheckers() {
+	healthchecks := make(map[string]AppHealthcheck)
+	for _, app := range svc.visibleApps() {
+		if app.Healthcheck.probed() {
+			healthchecks[app.ID] = app.Healthcheck
+		}
+	}
 
-	for url := range existingUrls {
-		if _, ok := newUrls[url]; !ok {
-			svc.healthCheckService.Remove(url)
+	for existing := range svc.healthCheckService.AppIds() {
+		if _, ok := healthchecks[existing]; !ok {
+			svc.healthCheckService.Remove(existing)
 		}
 	}
 
-	for url, cfg := range newUrls {
-		svc.healthCheckService.Add(url, cfg.Interval, cfg.Timeout)
+	for id, healthcheck := range healthchecks {
+		svc.healthCheckService.Add(id, healthcheck)
 	}
 }
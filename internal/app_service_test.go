@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func (f *fakeProvider) setApps(apps []App, notificationChan chan<- string) {
+	f.mutex.Lock()
+	f.apps = apps
+	f.mutex.Unlock()
+
+	notifyUpdate(f.ctx, notificationChan, f.id)
+}
+
+// TestAppService_concurrentStress runs providers, health checks, config reloads and websocket clients
+// at the same time. It is meant to be run with the race detector.
+func TestAppService_concurrentStress(t *testing.T) {
+	var requests atomic.Int64
+	healthServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// flip between healthy and unhealthy to generate a steady stream of health updates
+		if requests.Add(1)%2 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer healthServer.Close()
+
+	appsFile := filepath.Join(t.TempDir(), "apps.yml")
+	writeFile(t, appsFile, fmt.Sprintf("- {name: File, link: %s/file, group: File, healthcheck: {enable: true, interval: 10ms}}", healthServer.URL))
+
+	config := DefaultConfig()
+	config.App.Groups = []string{"Fake"}
+	reloadedConfig := DefaultConfig()
+	reloadedConfig.App.Name = "reloaded"
+	reloadedConfig.Providers.File["stress"] = FileProviderConfig{Path: appsFile}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthcheckService := NewHealthcheckService(ctx, "")
+	healthcheckService.Init()
+
+	svc := NewAppService(ctx, config, healthcheckService).(*appServiceImpl)
+	fakeProviders := make([]*fakeProvider, 0)
+	for i := range 4 {
+		provider := newFakeProvider(fmt.Sprintf("fake-%d", i))
+		fakeProviders = append(fakeProviders, provider)
+		svc.providers[provider.ID()] = newProviderSupervisor(ctx, provider)
+	}
+	svc.Init()
+
+	broadcaster := NewBroadcaster(ctx, svc)
+	broadcaster.Init()
+	websocketServer := NewWebsocketServer(ctx, broadcaster, svc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		websocketServer.Connect(context.Background(), conn)
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	run := func(iterations int, fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				fn(i)
+			}
+		}()
+	}
+
+	for _, provider := range fakeProviders {
+		run(200, func(i int) {
+			apps := make([]App, 0)
+			for j := range i%5 + 1 {
+				apps = append(apps, App{
+					Name:  fmt.Sprintf("%s-%d", provider.ID(), j),
+					Link:  fmt.Sprintf("%s/%s/%d", healthServer.URL, provider.ID(), j),
+					Group: "Fake",
+					Healthcheck: AppHealthcheck{
+						Enabled:  true,
+						Interval: 10 * time.Millisecond,
+						Timeout:  time.Second,
+					},
+				})
+			}
+			provider.setApps(apps, svc.providerUpdateCh)
+			time.Sleep(time.Millisecond)
+		})
+	}
+
+	// the last reload keeps the file provider, whose health checks are awaited below
+	run(10, func(i int) {
+		if i%2 == 0 {
+			svc.Reload(config)
+		} else {
+			svc.Reload(reloadedConfig)
+		}
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	run(1000, func(int) {
+		_ = svc.GetApps()
+		_ = svc.GetSettings()
+		_ = svc.GetProviderStatuses()
+	})
+
+	var messages atomic.Int64
+	var goingAway sync.WaitGroup
+	websocketUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+	for range 5 {
+		conn, _, err := websocket.DefaultDialer.Dial(websocketUrl, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer closeSafe(conn)
+
+		goingAway.Add(1)
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+						goingAway.Done()
+					}
+					return
+				}
+				messages.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// health checks keep running after the providers are done, so they eventually reach the clients
+	assert.Eventually(t, func() bool { return messages.Load() > 10 && requests.Load() > 10 }, 10*time.Second, 10*time.Millisecond)
+	assert.NotEmpty(t, svc.GetApps())
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	assert.NoError(t, svc.Shutdown(shutdownCtx))
+	assert.NoError(t, websocketServer.Shutdown(shutdownCtx))
+	goingAway.Wait()
+	for _, status := range svc.GetProviderStatuses() {
+		assert.Equal(t, ProviderStopped, status.State)
+	}
+}
+
+func TestAppService_buildAppGroups_mergesDiscoveredApps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := NewAppService(ctx, DefaultConfig(), NewHealthcheckService(ctx, "")).(*appServiceImpl)
+	svc.appsByProviderId = map[string][]App{
+		"traefik-local": {
+			{Name: "grafana@docker", Link: "https://grafana.example.com", Group: "Traefik", Icon: "traefik", Discovered: true},
+			{Name: "whoami@docker", Link: "https://whoami.example.com", Group: "Traefik", Icon: "traefik", Discovered: true},
+		},
+		"file-apps": {
+			{Name: "Grafana", Link: "https://grafana.example.com/", Group: "Monitoring", Icon: "grafana"},
+		},
+	}
+
+	names := make(map[string][]string)
+	for _, group := range svc.buildAppGroups() {
+		for _, app := range group.Apps {
+			names[group.Name] = append(names[group.Name], app.Name)
+			if app.Name == "Grafana" {
+				assert.Equal(t, "traefik-local", app.Provider)
+				assert.Equal(t, "grafana", app.Icon)
+			}
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"Monitoring": {"Grafana"},
+		"Traefik":    {"whoami@docker"},
+	}, names)
+}
@@ -1,6 +1,10 @@
 package internal
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestApp_resolveIconUrl(t *testing.T) {
 	tests := []struct {
@@ -44,3 +48,58 @@ func TestApp_resolveIconUrl(t *testing.T) {
 		})
 	}
 }
+
+func TestApp_Validate_healthcheckLink(t *testing.T) {
+	app := &App{Name: "app", Link: "https://app.example.com", Group: "group"}
+	app.Validate()
+	if app.Healthcheck.Link != app.Link {
+		t.Errorf("expected '%s' but got '%s'", app.Link, app.Healthcheck.Link)
+	}
+
+	app = &App{
+		Name:        "app",
+		Link:        "https://app.example.com",
+		Group:       "group",
+		Healthcheck: AppHealthcheck{Link: "http://app:8080/health"},
+	}
+	app.Validate()
+	if app.Healthcheck.Link != "http://app:8080/health" {
+		t.Errorf("expected '%s' but got '%s'", "http://app:8080/health", app.Healthcheck.Link)
+	}
+}
+
+func TestApp_marshalHidesHealthcheckHeaders(t *testing.T) {
+	app := App{
+		Name:  "app",
+		Link:  "https://app.example.com",
+		Group: "group",
+		Healthcheck: AppHealthcheck{
+			Headers: map[string]string{"Authorization": "Bearer secret-token"},
+			Link:    "http://10.0.0.5:8080/health",
+			DNSName: "internal.home.arpa",
+		},
+	}
+
+	bytes, err := json.Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bytes), "secret-token") || strings.Contains(string(bytes), "Authorization") {
+		t.Errorf("expected no healthcheck headers but got %s", bytes)
+	}
+	if strings.Contains(string(bytes), "10.0.0.5") || strings.Contains(string(bytes), "internal.home.arpa") {
+		t.Errorf("expected no healthcheck targets but got %s", bytes)
+	}
+}
+
+func Test_worseHealth(t *testing.T) {
+	if worseHealth(Healthy, Error) != Error || worseHealth(Error, Healthy) != Error {
+		t.Errorf("expected error to be worse than healthy")
+	}
+	if worseHealth(Warning, Timeout) != Timeout {
+		t.Errorf("expected timeout to be worse than warning")
+	}
+	if worseHealth(Unknown, Healthy) != Unknown {
+		t.Errorf("expected unknown to be worse than healthy")
+	}
+}
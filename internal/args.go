@@ -1,6 +1,10 @@
 package internal
 
-import "github.com/alecthomas/kong"
+import (
+	"time"
+
+	"github.com/alecthomas/kong"
+)
 
 type Args struct {
 	Host          string `name:"host"        default:"0.0.0.0" help:"host to listen on"           short:"l"`
@@ -11,7 +15,9 @@ type Args struct {
 		Level string `name:"level" default:"info" help:"log level" enum:"trace,debug,info,warn,error,fatal,panic"`
 		Type  string `name:"type" default:"text" help:"log type" enum:"text,json"`
 	} `embed:"" prefix:"log-"`
-	AccessLogs bool `name:"access-logs" default:"false" help:"enable access logs" type:"boolean"`
+	AccessLogs      bool          `name:"access-logs"      default:"false" help:"enable access logs"                                type:"boolean"`
+	ShutdownTimeout time.Duration `name:"shutdown-timeout" default:"10s"   help:"time to wait for connections and providers to stop"`
+	HealthHistory   string        `name:"health-history"   default:""      help:"Path to file to persist health check history, disabled if empty"`
 }
 
 func GetArgs() Args {
@@ -0,0 +1,554 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AuthBackendUsers  = "users"
+	AuthBackendTokens = "tokens"
+
+	authSessionCookie = "simplydash_session"
+	authUserKey       = "user"
+)
+
+// errNoCredentials is returned by backends when the request has none of their credentials,
+// so that the next backend is tried.
+var errNoCredentials = errors.New("no credentials")
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// AuthConfig enables auth as soon as any user or token is configured.
+type AuthConfig struct {
+	// Users maps user names to the bcrypt hashes of their passwords. Users log in with the login form,
+	// which starts a session, or with basic auth.
+	Users map[string]string `json:"users"         yaml:"users"`
+	// Tokens maps token names to the hex encoded sha256 hashes of bearer tokens, for api clients.
+	Tokens map[string]string `json:"tokens"        yaml:"tokens"`
+	// SessionTTL is how long sessions last before logging in again.
+	SessionTTL time.Duration `json:"session_ttl"   yaml:"session_ttl"`
+	// PublicHealth leaves /health open, for load balancers and uptime monitors.
+	PublicHealth bool `json:"public_health" yaml:"public_health"`
+	// AllowedOrigins are the origins, like https://home.example.com, allowed to open websockets, log in
+	// and call the api with CORS, besides the origin of the server itself.
+	AllowedOrigins []string `json:"allowed_origins" yaml:"allowed_origins"`
+}
+
+// AuthBackend authenticates requests with a single kind of credentials.
+type AuthBackend interface {
+	// Authenticate returns the user of the request, errNoCredentials if the request has none of the credentials
+	// of the backend, or another error if they are invalid.
+	Authenticate(c echo.Context) (string, error)
+}
+
+// PasswordChecker is implemented by backends knowing the passwords of users, which can log in with the login form.
+type PasswordChecker interface {
+	CheckPassword(user string, password string) error
+}
+
+// AuthBackendFactory builds a backend from the auth config, or returns nil if the config doesn't enable it.
+type AuthBackendFactory func(config AuthConfig) (AuthBackend, error)
+
+var authBackendFactories = map[string]AuthBackendFactory{
+	AuthBackendUsers:  newUsersBackend,
+	AuthBackendTokens: newTokensBackend,
+}
+
+// RegisterAuthBackend adds an auth backend, or replaces an existing one.
+// It is not safe for concurrent use, and must be called before the auth is created.
+func RegisterAuthBackend(name string, factory AuthBackendFactory) {
+	authBackendFactories[name] = factory
+}
+
+func newAuthBackends(config AuthConfig) ([]AuthBackend, error) {
+	names := make([]string, 0, len(authBackendFactories))
+	for name := range authBackendFactories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	backends := make([]AuthBackend, 0)
+	for _, name := range names {
+		backend, err := authBackendFactories[name](config)
+		if err != nil {
+			return nil, fmt.Errorf("%s auth: %w", name, err)
+		}
+		if backend != nil {
+			backends = append(backends, backend)
+		}
+	}
+	return backends, nil
+}
+
+// usersBackend checks the passwords of static users, given with basic auth or with the login form.
+type usersBackend struct {
+	hashes map[string][]byte
+}
+
+// dummyHash is compared against for unknown users, so that they take as long as known ones.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("simplydash"), bcrypt.DefaultCost)
+	return hash
+})
+
+func newUsersBackend(config AuthConfig) (AuthBackend, error) {
+	if len(config.Users) == 0 {
+		return nil, nil
+	}
+
+	hashes := make(map[string][]byte, len(config.Users))
+	for user, hash := range config.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("user %s: invalid bcrypt hash: %w", user, err)
+		}
+		hashes[user] = []byte(hash)
+	}
+	return &usersBackend{hashes: hashes}, nil
+}
+
+func (b *usersBackend) Authenticate(c echo.Context) (string, error) {
+	user, password, ok := c.Request().BasicAuth()
+	if !ok {
+		return "", errNoCredentials
+	}
+	return user, b.CheckPassword(user, password)
+}
+
+func (b *usersBackend) CheckPassword(user string, password string) error {
+	hash, ok := b.hashes[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return errInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return errInvalidCredentials
+	}
+	return nil
+}
+
+// tokensBackend checks bearer tokens against their hashes, so that the config doesn't hold the tokens themselves.
+type tokensBackend struct {
+	hashes map[string][]byte
+}
+
+func newTokensBackend(config AuthConfig) (AuthBackend, error) {
+	if len(config.Tokens) == 0 {
+		return nil, nil
+	}
+
+	hashes := make(map[string][]byte, len(config.Tokens))
+	for name, hash := range config.Tokens {
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("token %s: invalid sha256 hash", name)
+		}
+		hashes[name] = decoded
+	}
+	return &tokensBackend{hashes: hashes}, nil
+}
+
+func (b *tokensBackend) Authenticate(c echo.Context) (string, error) {
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return "", errNoCredentials
+	}
+
+	hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	for name, expected := range b.hashes {
+		if subtle.ConstantTimeCompare(hash[:], expected) == 1 {
+			return name, nil
+		}
+	}
+	return "", errInvalidCredentials
+}
+
+type session struct {
+	expires time.Time
+	// ctx is cancelled once the session ends, to close the streams opened with it
+	ctx    context.Context
+	cancel context.CancelFunc
+	user   string
+}
+
+// sessionBackend keeps the sessions started with the login form in memory, so they end when the server restarts.
+type sessionBackend struct {
+	sessions map[string]session
+	mutex    sync.Mutex
+}
+
+func newSessionBackend() *sessionBackend {
+	return &sessionBackend{sessions: make(map[string]session)}
+}
+
+func (b *sessionBackend) Authenticate(c echo.Context) (string, error) {
+	cookie, err := c.Cookie(authSessionCookie)
+	if err != nil {
+		return "", errNoCredentials
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	current, ok := b.sessions[cookie.Value]
+	if !ok || time.Now().After(current.expires) {
+		b.endLocked(cookie.Value)
+		// the browser is sent to the login form, as for requests without a session
+		return "", errNoCredentials
+	}
+	return current.user, nil
+}
+
+func (b *sessionBackend) start(user string, ttl time.Duration) (string, time.Time, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", time.Time{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(bytes)
+	expires := time.Now().Add(ttl)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	for expiredId, existing := range b.sessions {
+		if now.After(existing.expires) {
+			b.endLocked(expiredId)
+		}
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), expires)
+	b.sessions[id] = session{user: user, expires: expires, ctx: ctx, cancel: cancel}
+	return id, expires, nil
+}
+
+// context returns the context of the session, or one which is never cancelled for unknown sessions.
+func (b *sessionBackend) context(id string) context.Context {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if current, ok := b.sessions[id]; ok {
+		return current.ctx
+	}
+	return context.Background()
+}
+
+func (b *sessionBackend) end(id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.endLocked(id)
+}
+
+// endLocked must be called with the mutex held.
+func (b *sessionBackend) endLocked(id string) {
+	if current, ok := b.sessions[id]; ok {
+		current.cancel()
+		delete(b.sessions, id)
+	}
+}
+
+func (b *sessionBackend) clear() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for id := range b.sessions {
+		b.endLocked(id)
+	}
+}
+
+type authState struct {
+	config   AuthConfig
+	backends []AuthBackend
+}
+
+// Auth guards every route but the login form, and /health if it is public. The backends are tried in order,
+// starting with the sessions, and the first one finding its credentials in a request decides.
+type Auth struct {
+	state    atomic.Pointer[authState]
+	sessions *sessionBackend
+	logger   *slog.Logger
+}
+
+// NewAuth fails if the config is invalid. Auth is disabled if the config has no user and no token.
+func NewAuth(config AuthConfig) (*Auth, error) {
+	auth := &Auth{sessions: newSessionBackend(), logger: slog.With("name", "auth")}
+	state, err := auth.newState(config)
+	if err != nil {
+		return nil, err
+	}
+
+	auth.state.Store(state)
+	return auth, nil
+}
+
+func (a *Auth) newState(config AuthConfig) (*authState, error) {
+	if config.SessionTTL <= 0 {
+		config.SessionTTL = DefaultAuthSessionTTL
+	}
+
+	backends, err := newAuthBackends(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.ContainsFunc(backends, isPasswordChecker) {
+		backends = slices.Insert(backends, 0, AuthBackend(a.sessions))
+	}
+	return &authState{config: config, backends: backends}, nil
+}
+
+func isPasswordChecker(backend AuthBackend) bool {
+	_, ok := backend.(PasswordChecker)
+	return ok
+}
+
+// Reload applies a new config, keeping the current one if it is invalid. Changing the users ends all sessions.
+func (a *Auth) Reload(config Config) {
+	state, err := a.newState(config.Auth)
+	if err != nil {
+		a.logger.Error("invalid auth config, keeping the current config", "error", err)
+		return
+	}
+
+	previous := a.state.Swap(state)
+	if !reflect.DeepEqual(previous.config.Users, state.config.Users) {
+		a.sessions.clear()
+	}
+}
+
+func (a *Auth) enabled() bool {
+	return len(a.state.Load().backends) > 0
+}
+
+// sessionsEnabled tells whether users can log in with the login form.
+func (a *Auth) sessionsEnabled() bool {
+	return slices.Contains(a.state.Load().backends, AuthBackend(a.sessions))
+}
+
+// SessionContext is cancelled once the session of the request ends, by logging out, expiring or changing the users,
+// so that the streams opened with the session are closed. It is never cancelled for requests without a session.
+func (a *Auth) SessionContext(r *http.Request) context.Context {
+	cookie, err := r.Cookie(authSessionCookie)
+	if err != nil {
+		return context.Background()
+	}
+	return a.sessions.context(cookie.Value)
+}
+
+// CheckOrigin rejects cross-site requests, which browsers send along with the session cookie of the user.
+// Requests from the origin of the server or an allowed origin are accepted, and so are requests without
+// an Origin or a Referer, which don't come from browsers. Any origin is accepted while auth is disabled.
+func (a *Auth) CheckOrigin(r *http.Request) bool {
+	if !a.enabled() {
+		return true
+	}
+
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+
+	return a.allowsOrigin(parsed.Scheme + "://" + parsed.Host)
+}
+
+// allowsOrigin tells whether origin, like https://home.example.com, is one of the allowed origins.
+func (a *Auth) allowsOrigin(origin string) bool {
+	return slices.ContainsFunc(a.state.Load().config.AllowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+func (a *Auth) isPublic(path string) bool {
+	switch path {
+	case "/login", "/logout":
+		return true
+	case "/health":
+		return a.state.Load().config.PublicHealth
+	default:
+		return false
+	}
+}
+
+// Middleware rejects unauthenticated requests, sending browsers to the login form.
+func (a *Auth) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !a.enabled() || a.isPublic(c.Request().URL.Path) {
+				return next(c)
+			}
+
+			user, err := a.authenticate(c)
+			if err == nil {
+				c.Set(authUserKey, user)
+				return next(c)
+			}
+
+			if errors.Is(err, errNoCredentials) && c.Request().Method == http.MethodGet &&
+				strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML) {
+				return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+			}
+
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="simplydash"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+	}
+}
+
+func (a *Auth) authenticate(c echo.Context) (string, error) {
+	for _, backend := range a.state.Load().backends {
+		user, err := backend.Authenticate(c)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		if err != nil {
+			a.logger.Warn("authentication failed", "remoteIp", c.RealIP(), "error", err)
+		}
+		return user, err
+	}
+	return "", errNoCredentials
+}
+
+func (a *Auth) checkPassword(user string, password string) error {
+	for _, backend := range a.state.Load().backends {
+		if checker, ok := backend.(PasswordChecker); ok && checker.CheckPassword(user, password) == nil {
+			return nil
+		}
+	}
+	return errInvalidCredentials
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>simplydash - login</title>
+	<style>
+		body { font-family: sans-serif; display: flex; justify-content: center; margin-top: 20vh; }
+		form { display: flex; flex-direction: column; gap: 0.5rem; width: 16rem; }
+		.error { color: #e11d48; }
+	</style>
+</head>
+<body>
+	<form method="post" action="/login">
+		<h1>simplydash</h1>
+		{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+		<input type="hidden" name="next" value="{{.Next}}">
+		<input name="username" placeholder="username" autocomplete="username" required autofocus>
+		<input name="password" type="password" placeholder="password" autocomplete="current-password" required>
+		<button type="submit">log in</button>
+	</form>
+</body>
+</html>
+`))
+
+type loginPage struct {
+	Error string
+	Next  string
+}
+
+// setupRoutes registers the login form, which starts sessions, and the logout.
+// They are only served while some users can log in, which may change with reloads.
+func (a *Auth) setupRoutes(e *echo.Echo) {
+	e.GET("/login", func(c echo.Context) error {
+		return a.renderLogin(c, http.StatusOK, loginPage{Next: safeRedirect(c.QueryParam("next"))})
+	}, a.requireSessions)
+	e.POST("/login", a.login, a.requireSessions)
+	e.POST("/logout", a.logout, a.requireSessions)
+}
+
+func (a *Auth) requireSessions(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !a.sessionsEnabled() {
+			return echo.ErrNotFound
+		}
+		return next(c)
+	}
+}
+
+func (a *Auth) renderLogin(c echo.Context, status int, page loginPage) error {
+	var body strings.Builder
+	if err := loginTemplate.Execute(&body, page); err != nil {
+		return err
+	}
+	return c.HTML(status, body.String())
+}
+
+func (a *Auth) login(c echo.Context) error {
+	if !a.CheckOrigin(c.Request()) {
+		return echo.NewHTTPError(http.StatusForbidden, "cross-origin request")
+	}
+
+	next := safeRedirect(c.FormValue("next"))
+	user := c.FormValue("username")
+	if err := a.checkPassword(user, c.FormValue("password")); err != nil {
+		a.logger.Warn("login failed", "user", user, "remoteIp", c.RealIP())
+		return a.renderLogin(c, http.StatusUnauthorized, loginPage{Error: "invalid username or password", Next: next})
+	}
+
+	id, expires, err := a.sessions.start(user, a.state.Load().config.SessionTTL)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     authSessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func (a *Auth) logout(c echo.Context) error {
+	if !a.CheckOrigin(c.Request()) {
+		return echo.NewHTTPError(http.StatusForbidden, "cross-origin request")
+	}
+
+	if cookie, err := c.Cookie(authSessionCookie); err == nil {
+		a.sessions.end(cookie.Value)
+	}
+
+	c.SetCookie(&http.Cookie{Name: authSessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// safeRedirect only allows redirects to local paths, so that the login form can't send users to another site.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testAuthConfig(t *testing.T) AuthConfig {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenHash := sha256.Sum256([]byte("api-token"))
+
+	return AuthConfig{
+		Users:        map[string]string{"admin": string(hash)},
+		Tokens:       map[string]string{"monitoring": hex.EncodeToString(tokenHash[:])},
+		PublicHealth: true,
+	}
+}
+
+func newTestEcho(t *testing.T, config AuthConfig) (*echo.Echo, *Auth) {
+	auth, err := NewAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := CreateEcho(Args{}, auth)
+	e.GET("/health", getHealth)
+	e.GET("/settings", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get(authUserKey).(string))
+	})
+	return e, auth
+}
+
+func serve(e *echo.Echo, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAuth_disabled(t *testing.T) {
+	auth, err := NewAuth(AuthConfig{})
+	assert.NoError(t, err)
+
+	e := CreateEcho(Args{}, auth)
+	e.GET("/settings", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	assert.Equal(t, http.StatusOK, serve(e, httptest.NewRequest(http.MethodGet, "/settings", nil)).Code)
+	assert.Equal(t, http.StatusNotFound, serve(e, httptest.NewRequest(http.MethodGet, "/login", nil)).Code,
+		"there is no login form without users")
+}
+
+func TestAuth_credentials(t *testing.T) {
+	e, _ := newTestEcho(t, testAuthConfig(t))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+		body   string
+	}{
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "basic auth", header: "Authorization", value: "Basic YWRtaW46c2VjcmV0", status: http.StatusOK, body: "admin"},
+		{name: "wrong password", header: "Authorization", value: "Basic YWRtaW46d3Jvbmc=", status: http.StatusUnauthorized},
+		{name: "unknown user", header: "Authorization", value: "Basic b3RoZXI6c2VjcmV0", status: http.StatusUnauthorized},
+		{name: "bearer token", header: "Authorization", value: "Bearer api-token", status: http.StatusOK, body: "monitoring"},
+		{name: "wrong token", header: "Authorization", value: "Bearer other", status: http.StatusUnauthorized},
+		{name: "unknown session", header: "Cookie", value: authSessionCookie + "=unknown", status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/settings", nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.value)
+			}
+
+			response := serve(e, request)
+			assert.Equal(t, test.status, response.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.body, response.Body.String())
+			} else {
+				assert.Equal(t, `Bearer realm="simplydash"`, response.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+
+	assert.Equal(t, http.StatusOK, serve(e, httptest.NewRequest(http.MethodGet, "/health", nil)).Code,
+		"the health endpoint is public")
+}
+
+func TestAuth_loginSession(t *testing.T) {
+	e, auth := newTestEcho(t, testAuthConfig(t))
+
+	page := httptest.NewRequest(http.MethodGet, "/settings?x=1", nil)
+	page.Header.Set(echo.HeaderAccept, "text/html,application/xhtml+xml")
+	response := serve(e, page)
+	assert.Equal(t, http.StatusSeeOther, response.Code, "browsers are sent to the login form")
+	assert.Equal(t, "/login?next=%2Fsettings%3Fx%3D1", response.Header().Get(echo.HeaderLocation))
+
+	form := serve(e, httptest.NewRequest(http.MethodGet, response.Header().Get(echo.HeaderLocation), nil))
+	assert.Equal(t, http.StatusOK, form.Code)
+	assert.Contains(t, form.Body.String(), `name="next" value="/settings?x=1"`)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		values := url.Values{"username": {"admin"}, "password": {password}, "next": {"/settings"}}
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(values.Encode()))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		return serve(e, request)
+	}
+
+	failed := login("wrong")
+	assert.Equal(t, http.StatusUnauthorized, failed.Code)
+	assert.Contains(t, failed.Body.String(), "invalid username or password")
+
+	response = login("secret")
+	assert.Equal(t, http.StatusSeeOther, response.Code)
+	assert.Equal(t, "/settings", response.Header().Get(echo.HeaderLocation))
+	cookie := response.Result().Cookies()[0]
+	assert.Equal(t, authSessionCookie, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+
+	withSession := func(method string, target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		request.AddCookie(cookie)
+		return serve(e, request)
+	}
+	assert.Equal(t, http.StatusOK, withSession(http.MethodGet, "/settings").Code)
+	sessionContext := func() context.Context {
+		request := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		request.AddCookie(cookie)
+		return auth.SessionContext(request)
+	}
+	session := sessionContext()
+	assert.NoError(t, session.Err())
+
+	assert.Equal(t, http.StatusSeeOther, withSession(http.MethodPost, "/logout").Code)
+	assert.Equal(t, http.StatusUnauthorized, withSession(http.MethodGet, "/settings").Code, "logging out ends the session")
+	assert.Error(t, session.Err(), "the streams of the session are closed")
+
+	cookie = login("secret").Result().Cookies()[0]
+	session = sessionContext()
+	config := DefaultConfig()
+	config.Auth = testAuthConfig(t)
+	auth.Reload(config)
+	assert.Equal(t, http.StatusUnauthorized, withSession(http.MethodGet, "/settings").Code, "changing the users ends the sessions")
+	assert.Error(t, session.Err())
+}
+
+func TestAuth_CheckOrigin(t *testing.T) {
+	config := testAuthConfig(t)
+	config.AllowedOrigins = []string{"https://home.example.com/"}
+	auth, err := NewAuth(config)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		allowed bool
+	}{
+		{name: "no origin", allowed: true},
+		{name: "same origin", header: "Origin", value: "http://example.com", allowed: true},
+		{name: "allowed origin", header: "Origin", value: "https://home.example.com", allowed: true},
+		{name: "other origin", header: "Origin", value: "https://evil.example.net"},
+		{name: "allowed origin with another scheme", header: "Origin", value: "http://home.example.com"},
+		{name: "same origin referer", header: "Referer", value: "http://example.com/login", allowed: true},
+		{name: "other referer", header: "Referer", value: "https://evil.example.net/login"},
+		{name: "opaque origin", header: "Origin", value: "null"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.value)
+			}
+			assert.Equal(t, test.allowed, auth.CheckOrigin(request))
+		})
+	}
+
+	disabled, err := NewAuth(AuthConfig{})
+	assert.NoError(t, err)
+	request := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	request.Header.Set(echo.HeaderOrigin, "https://evil.example.net")
+	assert.True(t, disabled.CheckOrigin(request), "any origin is accepted without auth")
+}
+
+func TestAuth_cors(t *testing.T) {
+	config := testAuthConfig(t)
+	config.AllowedOrigins = []string{"https://home.example.com"}
+	e, _ := newTestEcho(t, config)
+
+	for origin, allowed := range map[string]string{
+		"https://home.example.com": "https://home.example.com",
+		"https://evil.example.net": "",
+	} {
+		request := httptest.NewRequest(http.MethodOptions, "/settings", nil)
+		request.Header.Set(echo.HeaderOrigin, origin)
+		request.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+		assert.Equal(t, allowed, serve(e, request).Header().Get(echo.HeaderAccessControlAllowOrigin))
+	}
+}
+
+func TestAuth_loginCrossOrigin(t *testing.T) {
+	e, _ := newTestEcho(t, testAuthConfig(t))
+
+	values := url.Values{"username": {"admin"}, "password": {"secret"}}
+	request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(values.Encode()))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	request.Header.Set(echo.HeaderOrigin, "https://evil.example.net")
+	response := serve(e, request)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Empty(t, response.Result().Cookies())
+}
+
+func TestNewAuth_invalidConfig(t *testing.T) {
+	_, err := NewAuth(AuthConfig{Users: map[string]string{"admin": "plain"}})
+	assert.ErrorContains(t, err, "users auth: user admin: invalid bcrypt hash")
+
+	_, err = NewAuth(AuthConfig{Tokens: map[string]string{"monitoring": "api-token"}})
+	assert.EqualError(t, err, "tokens auth: token monitoring: invalid sha256 hash")
+}
+
+func Test_safeRedirect(t *testing.T) {
+	assert.Equal(t, "/apps?group=media", safeRedirect("/apps?group=media"))
+	assert.Equal(t, "/", safeRedirect(""))
+	assert.Equal(t, "/", safeRedirect("https://example.com"))
+	assert.Equal(t, "/", safeRedirect("//example.com"))
+	assert.Equal(t, "/", safeRedirect(`/\example.com`))
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	broadcastApps     = "apps"
+	broadcastSettings = "settings"
+	broadcastSnapshot = "snapshot"
+)
+
+// BroadcastEvent is a full snapshot of the apps or of the settings, or a delta of the apps.
+// Seq orders the events of a broadcaster.
+type BroadcastEvent struct {
+	Type string
+	Data json.RawMessage
+	Seq  uint64
+}
+
+// BroadcastSnapshot is the data of snapshot events, which delta subscribers get first.
+type BroadcastSnapshot struct {
+	Settings json.RawMessage `json:"settings"`
+	Apps     json.RawMessage `json:"apps"`
+}
+
+// Broadcaster turns the updates of the app service into events shared by the websocket and server-sent events
+// transports, so that both send the same data in the same order.
+//
+// Snapshot subscribers get the full apps and settings on every change. Delta subscribers get a snapshot,
+// then only the apps that changed, with consecutive seqs. A delta subscriber which falls behind, or a change
+// deltas can't express, gets a new snapshot instead.
+type Broadcaster struct {
+	ctx         context.Context
+	appService  AppService
+	logger      *slog.Logger
+	latest      map[string]BroadcastEvent
+	subscribers map[*Subscription]struct{}
+	// settings and appGroups are the last published ones, owned by the run goroutine
+	settings  AppConfig
+	appGroups []AppGroup
+	// epoch tells the events of this process apart from the ones of a previous run, when resuming
+	epoch string
+	seq   uint64
+	mutex sync.Mutex
+}
+
+func NewBroadcaster(ctx context.Context, appService AppService) *Broadcaster {
+	b := &Broadcaster{
+		ctx:         ctx,
+		appService:  appService,
+		logger:      slog.With("name", "broadcaster"),
+		latest:      make(map[string]BroadcastEvent),
+		subscribers: make(map[*Subscription]struct{}),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	b.publish(appService.GetSettings(), appService.GetApps())
+	return b
+}
+
+func (b *Broadcaster) Init() {
+	go b.run()
+}
+
+func (b *Broadcaster) run() {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-b.appService.UpdateCh():
+		}
+
+		b.publish(b.appService.GetSettings(), b.appService.GetApps())
+	}
+}
+
+func (b *Broadcaster) publish(settings AppConfig, appGroups []AppGroup) {
+	settingsChanged := b.seq == 0 || !reflect.DeepEqual(settings, b.settings)
+	deltas, ok := diffAppGroups(b.appGroups, appGroups)
+	if !settingsChanged && ok && len(deltas) == 0 {
+		return
+	}
+	b.settings = settings
+	b.appGroups = appGroups
+
+	appsData, err := json.Marshal(appGroups)
+	if err != nil {
+		b.logger.Error("marshalling json", "type", broadcastApps, "error", err)
+		return
+	}
+
+	if settingsChanged || !ok {
+		settingsData, err := json.Marshal(settings)
+		if err != nil {
+			b.logger.Error("marshalling json", "type", broadcastSettings, "error", err)
+			return
+		}
+		b.publishSnapshot(settingsChanged, settingsData, appsData)
+		return
+	}
+
+	deltaEvents := make([]BroadcastEvent, 0, len(deltas))
+	for _, delta := range deltas {
+		data, err := json.Marshal(delta)
+		if err != nil {
+			b.logger.Error("marshalling json", "type", delta.Type, "error", err)
+			return
+		}
+		deltaEvents = append(deltaEvents, BroadcastEvent{Type: delta.Type, Data: data})
+	}
+	b.publishDeltas(deltaEvents, appsData)
+}
+
+func (b *Broadcaster) publishSnapshot(settingsChanged bool, settingsData, appsData json.RawMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	events := make([]BroadcastEvent, 0, 2)
+	if settingsChanged {
+		events = append(events, BroadcastEvent{Type: broadcastSettings, Data: settingsData, Seq: b.seq})
+	}
+	events = append(events, BroadcastEvent{Type: broadcastApps, Data: appsData, Seq: b.seq})
+	for _, event := range events {
+		b.latest[event.Type] = event
+	}
+
+	snapshot := b.snapshot()
+	for subscription := range b.subscribers {
+		if subscription.deltas {
+			subscription.reset(snapshot)
+		} else {
+			subscription.push(events...)
+		}
+	}
+}
+
+func (b *Broadcaster) publishDeltas(deltaEvents []BroadcastEvent, appsData json.RawMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i := range deltaEvents {
+		b.seq++
+		deltaEvents[i].Seq = b.seq
+	}
+	apps := BroadcastEvent{Type: broadcastApps, Data: appsData, Seq: b.seq}
+	b.latest[broadcastApps] = apps
+
+	for subscription := range b.subscribers {
+		if !subscription.deltas {
+			subscription.push(apps)
+		} else if !subscription.push(deltaEvents...) {
+			subscription.reset(b.snapshot())
+		}
+	}
+}
+
+// snapshot returns the latest apps and settings as a single event. The caller holds the mutex.
+func (b *Broadcaster) snapshot() BroadcastEvent {
+	data, err := json.Marshal(BroadcastSnapshot{
+		Settings: b.latest[broadcastSettings].Data,
+		Apps:     b.latest[broadcastApps].Data,
+	})
+	if err != nil {
+		b.logger.Error("marshalling json", "type", broadcastSnapshot, "error", err)
+	}
+	return BroadcastEvent{Type: broadcastSnapshot, Data: data, Seq: b.seq}
+}
+
+// Subscribe returns a snapshot subscription starting with the latest event of every type newer than since,
+// so that clients resuming from an event only get what changed. Zero gets everything.
+func (b *Broadcaster) Subscribe(since uint64) *Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscription := newSubscription(false)
+	for _, eventType := range []string{broadcastSettings, broadcastApps} {
+		if event := b.latest[eventType]; event.Seq > since {
+			subscription.push(event)
+		}
+	}
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// SubscribeDeltas returns a delta subscription starting with a snapshot.
+func (b *Broadcaster) SubscribeDeltas() *Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscription := newSubscription(true)
+	subscription.reset(b.snapshot())
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Resync sends a new snapshot to a delta subscription, for clients which lost track of the deltas.
+func (b *Broadcaster) Resync(subscription *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscription.reset(b.snapshot())
+}
+
+func (b *Broadcaster) Unsubscribe(subscription *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, subscription)
+}
+
+// EventId identifies an event across processes, for server-sent events.
+func (b *Broadcaster) EventId(event BroadcastEvent) string {
+	return fmt.Sprintf("%s-%d", b.epoch, event.Seq)
+}
+
+// ParseEventId returns the seq of an event id, or zero if it is invalid or comes from another process.
+func (b *Broadcaster) ParseEventId(id string) uint64 {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0
+	}
+
+	parsed, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
+// Subscription never blocks the broadcaster. Snapshot subscriptions replace the events which weren't read yet
+// by newer events of the same type. Delta subscriptions queue them, up to DefaultBroadcastQueueSize.
+type Subscription struct {
+	notifyCh chan struct{}
+	pending  []BroadcastEvent
+	deltas   bool
+	mutex    sync.Mutex
+}
+
+func newSubscription(deltas bool) *Subscription {
+	return &Subscription{
+		notifyCh: make(chan struct{}, 1),
+		pending:  make([]BroadcastEvent, 0),
+		deltas:   deltas,
+	}
+}
+
+// push returns false if a delta subscription is full, and needs a snapshot instead.
+func (s *Subscription) push(events ...BroadcastEvent) bool {
+	s.mutex.Lock()
+	if s.deltas && len(s.pending)+len(events) > DefaultBroadcastQueueSize {
+		s.mutex.Unlock()
+		return false
+	}
+
+	for _, event := range events {
+		if !s.deltas {
+			s.pending = slices.DeleteFunc(s.pending, func(pending BroadcastEvent) bool { return pending.Type == event.Type })
+		}
+		s.pending = append(s.pending, event)
+	}
+	s.mutex.Unlock()
+
+	s.notify()
+	return true
+}
+
+// reset replaces the pending events with a snapshot, which supersedes them.
+func (s *Subscription) reset(snapshot BroadcastEvent) {
+	s.mutex.Lock()
+	s.pending = append(s.pending[:0], snapshot)
+	s.mutex.Unlock()
+
+	s.notify()
+}
+
+func (s *Subscription) notify() {
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Notify receives when there are events to read.
+func (s *Subscription) Notify() <-chan struct{} {
+	return s.notifyCh
+}
+
+// Events takes the pending events, ordered by seq.
+func (s *Subscription) Events() []BroadcastEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := s.pending
+	s.pending = make([]BroadcastEvent, 0)
+	return events
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBroadcaster(t *testing.T) (*Broadcaster, *fakeAppService) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	appService := &fakeAppService{updateCh: make(chan struct{}), appGroups: []AppGroup{}, settings: AppConfig{Name: "dash"}}
+	broadcaster := NewBroadcaster(ctx, appService)
+	broadcaster.Init()
+	return broadcaster, appService
+}
+
+func nextEvents(t *testing.T, subscription *Subscription) []BroadcastEvent {
+	t.Helper()
+	select {
+	case <-subscription.Notify():
+		return subscription.Events()
+	case <-time.After(time.Second):
+		t.Fatal("no events")
+		return nil
+	}
+}
+
+func eventTypes(events []BroadcastEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestBroadcaster_subscribe(t *testing.T) {
+	broadcaster, appService := newTestBroadcaster(t)
+
+	subscription := broadcaster.Subscribe(0)
+	defer broadcaster.Unsubscribe(subscription)
+	initial := nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastSettings, broadcastApps}, eventTypes(initial))
+	assert.JSONEq(t, `{"name": "dash", "groups": null}`, string(initial[0].Data))
+
+	appService.update([]AppGroup{NewAppGroup("media")}, AppConfig{})
+	events := nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastApps}, eventTypes(events), "unchanged settings aren't sent again")
+	assert.Greater(t, events[0].Seq, initial[1].Seq)
+
+	resumed := broadcaster.Subscribe(initial[1].Seq)
+	defer broadcaster.Unsubscribe(resumed)
+	assert.Equal(t, events, nextEvents(t, resumed), "resuming only gets the newer events")
+}
+
+func TestBroadcaster_coalescesPendingEvents(t *testing.T) {
+	broadcaster, appService := newTestBroadcaster(t)
+
+	subscription := broadcaster.Subscribe(0)
+	defer broadcaster.Unsubscribe(subscription)
+	for i := range 5 {
+		appService.update([]AppGroup{NewAppGroup(string(rune('a' + i)))}, AppConfig{})
+	}
+	appService.update([]AppGroup{NewAppGroup("last")}, AppConfig{Name: "renamed"})
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(string(latestEvent(broadcaster, broadcastSettings).Data), "renamed")
+	}, time.Second, 10*time.Millisecond)
+	events := nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastSettings, broadcastApps}, eventTypes(events))
+	assert.JSONEq(t, `[{"name": "last", "apps": []}]`, string(events[1].Data))
+}
+
+func TestBroadcaster_deltas(t *testing.T) {
+	broadcaster, appService := newTestBroadcaster(t)
+
+	subscription := broadcaster.SubscribeDeltas()
+	defer broadcaster.Unsubscribe(subscription)
+	initial := nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastSnapshot}, eventTypes(initial))
+	assert.JSONEq(t, `{"settings": {"name": "dash", "groups": null}, "apps": []}`, string(initial[0].Data))
+
+	app := deltaTestApp("one", "a", Healthy)
+	appService.update(deltaTestGroups(app), AppConfig{Name: "dash"})
+	snapshot := nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastSnapshot}, eventTypes(snapshot), "new groups need a snapshot")
+
+	app.Healthcheck.Health = Error
+	appService.update(deltaTestGroups(app), AppConfig{Name: "dash"})
+	events := nextEvents(t, subscription)
+	assert.Equal(t, []string{deltaHealthChanged}, eventTypes(events))
+	assert.Equal(t, snapshot[0].Seq+1, events[0].Seq)
+	assert.Contains(t, string(events[0].Data), `"health":"error"`)
+
+	// publishing directly, as the app service only notifies about the latest apps
+	for i := range DefaultBroadcastQueueSize + 1 {
+		app.Healthcheck.Health = []AppHealth{Healthy, Error}[i%2]
+		broadcaster.publish(AppConfig{Name: "dash"}, deltaTestGroups(app))
+	}
+	expectedSeq := events[0].Seq + DefaultBroadcastQueueSize + 1
+	events = nextEvents(t, subscription)
+	assert.Equal(t, []string{broadcastSnapshot}, eventTypes(events), "subscribers falling behind get a snapshot")
+	assert.Equal(t, expectedSeq, events[0].Seq)
+}
+
+func latestEvent(broadcaster *Broadcaster, eventType string) BroadcastEvent {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	return broadcaster.latest[eventType]
+}
+
+func TestBroadcaster_eventIds(t *testing.T) {
+	broadcaster, _ := newTestBroadcaster(t)
+	other, _ := newTestBroadcaster(t)
+	other.epoch = "other"
+
+	event := BroadcastEvent{Seq: 42}
+	assert.Equal(t, uint64(42), broadcaster.ParseEventId(broadcaster.EventId(event)))
+	assert.Equal(t, uint64(0), broadcaster.ParseEventId(other.EventId(event)), "ids of a previous run start over")
+	assert.Equal(t, uint64(0), broadcaster.ParseEventId("invalid"))
+}
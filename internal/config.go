@@ -1,16 +1,21 @@
 package internal
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
 
+	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Providers Providers `json:"providers" yaml:"providers"`
-	App       AppConfig `json:"app"       yaml:"app"`
+	Providers     Providers           `json:"providers"     yaml:"providers"`
+	App           AppConfig           `json:"app"           yaml:"app"`
+	Notifications NotificationsConfig `json:"notifications" yaml:"notifications"`
+	Auth          AuthConfig          `json:"auth"          yaml:"auth"`
 }
 
 type AppConfig struct {
@@ -19,20 +24,35 @@ type AppConfig struct {
 }
 
 type Providers struct {
-	File   map[string]FileProviderConfig   `json:"file"   yaml:"file"`
-	Docker map[string]DockerProviderConfig `json:"docker" yaml:"docker"`
+	File       map[string]FileProviderConfig       `json:"file"       yaml:"file"`
+	Docker     map[string]DockerProviderConfig     `json:"docker"     yaml:"docker"`
+	Kubernetes map[string]KubernetesProviderConfig `json:"kubernetes" yaml:"kubernetes"`
+	Traefik    map[string]TraefikProviderConfig    `json:"traefik"    yaml:"traefik"`
+	HTTP       map[string]HTTPProviderConfig       `json:"http"       yaml:"http"`
 }
 
 func DefaultConfig() Config {
 	return Config{
 		Providers: Providers{
-			File:   map[string]FileProviderConfig{},
-			Docker: map[string]DockerProviderConfig{},
+			File:       map[string]FileProviderConfig{},
+			Docker:     map[string]DockerProviderConfig{},
+			Kubernetes: map[string]KubernetesProviderConfig{},
+			Traefik:    map[string]TraefikProviderConfig{},
+			HTTP:       map[string]HTTPProviderConfig{},
 		},
 		App: AppConfig{
 			Name:   "simplydash",
 			Groups: []string{},
 		},
+		Notifications: NotificationsConfig{
+			Notifiers: map[string]NotifierConfig{},
+			Rules:     []NotificationRule{},
+		},
+		Auth: AuthConfig{
+			Users:          map[string]string{},
+			Tokens:         map[string]string{},
+			AllowedOrigins: []string{},
+		},
 	}
 }
 
@@ -58,6 +78,39 @@ func GetConfig(args Args) (Config, error) {
 	return config, nil
 }
 
+// WatchConfig calls onChange with the new config whenever the config file changes.
+// Configs which fail to load are reported and skipped, leaving the running config in place.
+// The config file is watched until ctx is cancelled.
+func WatchConfig(ctx context.Context, args Args, onChange func(Config)) error {
+	absPath, err := filepath.Abs(args.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	logger := slog.With("name", "config-watcher", "configFile", absPath)
+	matches := func(event fsnotify.Event) bool {
+		return isConfigMapFlip(event) || filepath.Clean(event.Name) == absPath
+	}
+
+	reload := func() {
+		if _, err := os.Stat(absPath); err != nil {
+			logger.Warn("config file missing, keeping the current config", "error", err)
+			return
+		}
+
+		config, err := GetConfig(args)
+		if err != nil {
+			logger.Error("invalid config, keeping the current config", "error", err)
+			return
+		}
+
+		logger.Info("config reloaded")
+		onChange(config)
+	}
+
+	return newFileWatcher(ctx, filepath.Dir(absPath), matches, reload, logger).Start(func(fn func()) { go fn() })
+}
+
 func createConfigFile(configPath string, config Config) {
 	err := os.MkdirAll(path.Dir(configPath), 0o755)
 	if err != nil {
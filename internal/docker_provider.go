@@ -3,14 +3,16 @@ package internal
 import (
 	"context"
 	"errors"
-	"fmt"
-	"github.com/docker/docker/api/types/container"
 	"log/slog"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 )
@@ -23,9 +25,27 @@ const (
 	simplydashGroup               = simplydash + ".group"
 	simplydashIcon                = simplydash + ".icon"
 	simplydashDescription         = simplydash + ".description"
+	simplydashTags                = simplydash + ".tags"
 	simplydashHealthcheckEnable   = simplydash + ".healthcheck.enable"
+	simplydashHealthcheckLink     = simplydash + ".healthcheck.link"
 	simplydashHealthcheckInterval = simplydash + ".healthcheck.interval"
 	simplydashHealthcheckTimeout  = simplydash + ".healthcheck.timeout"
+
+	simplydashHealthcheckMethod           = simplydash + ".healthcheck.method"
+	simplydashHealthcheckHeaders          = simplydash + ".healthcheck.headers."
+	simplydashHealthcheckStatusCodes      = simplydash + ".healthcheck.status_codes"
+	simplydashHealthcheckBodyRegex        = simplydash + ".healthcheck.body_regex"
+	simplydashHealthcheckJSONPath         = simplydash + ".healthcheck.json_path"
+	simplydashHealthcheckJSONValue        = simplydash + ".healthcheck.json_value"
+	simplydashHealthcheckFollowRedirects  = simplydash + ".healthcheck.follow_redirects"
+	simplydashHealthcheckMaxLatency       = simplydash + ".healthcheck.max_latency"
+	simplydashHealthcheckType             = simplydash + ".healthcheck.type"
+	simplydashHealthcheckSource           = simplydash + ".healthcheck.source"
+	simplydashHealthcheckDNSName          = simplydash + ".healthcheck.dns_name"
+	simplydashHealthcheckCertExpiryDays   = simplydash + ".healthcheck.cert_expiry_days"
+	simplydashHealthcheckRetries          = simplydash + ".healthcheck.retries"
+	simplydashHealthcheckFailureThreshold = simplydash + ".healthcheck.failure_threshold"
+	simplydashHealthcheckSuccessThreshold = simplydash + ".healthcheck.success_threshold"
 )
 
 type DockerProviderConfig struct {
@@ -35,35 +55,39 @@ type DockerProviderConfig struct {
 }
 
 type DockerProvider struct {
-	clientFunc       DockerClientFunc
-	logger           *slog.Logger
-	notificationChan chan<- string
-	id               string
-	apps             []App
-	config           DockerProviderConfig
+	*providerRuntime
+	clientFunc        DockerClientFunc
+	logger            *slog.Logger
+	notificationChan  chan<- string
+	appsByContainerId map[string]App
+	id                string
+	config            DockerProviderConfig
+	mutex             sync.RWMutex
 }
 
 type DockerClientFunc func(config DockerProviderConfig) (client.APIClient, error)
 
 func RealDockerClientFunc() DockerClientFunc {
 	return func(config DockerProviderConfig) (client.APIClient, error) {
+		// no client-wide timeout here, it would also cut the long-lived event stream;
+		// the configured timeout is applied to each individual request instead
 		return client.NewClientWithOpts(
 			client.WithHost(config.Host),
-			client.WithTimeout(config.Timeout),
 			client.WithAPIVersionNegotiation(),
 		)
 	}
 }
 
-func NewDockerProvider(name string, config DockerProviderConfig, notificationChan chan<- string) Provider {
-	id := fmt.Sprintf("docker-%s", name)
+func NewDockerProvider(ctx context.Context, name string, config DockerProviderConfig, notificationChan chan<- string) Provider {
+	id := providerId("docker", name)
 	return &DockerProvider{
-		id:               id,
-		apps:             make([]App, 0),
-		config:           config,
-		clientFunc:       RealDockerClientFunc(),
-		notificationChan: notificationChan,
-		logger:           slog.With("id", id),
+		providerRuntime:   newProviderRuntime(ctx, id),
+		id:                id,
+		appsByContainerId: make(map[string]App),
+		config:            config,
+		clientFunc:        RealDockerClientFunc(),
+		notificationChan:  notificationChan,
+		logger:            slog.With("id", id),
 	}
 }
 
@@ -72,88 +96,310 @@ func (dp *DockerProvider) ID() string {
 }
 
 func (dp *DockerProvider) Apps() []App {
-	return dp.apps
+	dp.mutex.RLock()
+	defer dp.mutex.RUnlock()
+
+	apps := make([]App, 0, len(dp.appsByContainerId))
+	for _, app := range dp.appsByContainerId {
+		apps = insertOrdered(apps, app)
+	}
+	return apps
 }
 
 func (dp *DockerProvider) Init() error {
-	go dp.fetch()
-	go dp.poll()
+	if dp.config.Interval <= 0 {
+		dp.config.Interval = DefaultDockerResyncInterval
+	}
+
+	if dp.config.Timeout <= 0 {
+		dp.config.Timeout = DefaultDockerTimeout
+	}
+
+	dp.spawn(dp.run)
 	return nil
 }
 
-func (dp *DockerProvider) poll() {
-	if dp.config.Interval <= 0 {
-		dp.config.Interval = time.Minute
+func (dp *DockerProvider) run() {
+	backoff := DefaultDockerMinBackoff
+	for {
+		err := dp.watch(func() { backoff = DefaultDockerMinBackoff })
+		if dp.ctx.Err() != nil {
+			return
+		}
+		dp.logger.Error("docker event stream", "error", err, "retryIn", backoff)
+		dp.failed(err)
+
+		select {
+		case <-dp.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, DefaultDockerMaxBackoff)
+	}
+}
+
+// watch subscribes to container events and applies them to the app list until the
+// event stream breaks. A full resync is done on connect and on every config interval.
+func (dp *DockerProvider) watch(onConnected func()) error {
+	dockerClient, err := dp.clientFunc(dp.config)
+	if err != nil {
+		return err
+	}
+	defer closeSafe(dockerClient)
+
+	ctx, cancel := context.WithCancel(dp.ctx)
+	defer cancel()
+
+	// subscribe before the initial sync, so that no event is lost in between
+	messages, errs := dockerClient.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", simplydashEnable),
+			filters.Arg("event", string(events.ActionCreate)),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionRestart)),
+			filters.Arg("event", string(events.ActionStop)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionPause)),
+			filters.Arg("event", string(events.ActionUnPause)),
+			filters.Arg("event", string(events.ActionRename)),
+			filters.Arg("event", string(events.ActionUpdate)),
+			filters.Arg("event", string(events.ActionDestroy)),
+			// the daemon matches health_status: healthy, unhealthy, etc.
+			filters.Arg("event", string(events.ActionHealthStatus)),
+		),
+	})
+
+	err = dp.sync(ctx, dockerClient)
+	if err != nil {
+		return err
 	}
+	onConnected()
 
 	ticker := time.NewTicker(dp.config.Interval)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		dp.fetch()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case message := <-messages:
+			dp.logger.Debug("container event", "action", message.Action, "containerId", message.Actor.ID)
+			err = dp.handleEvent(ctx, dockerClient, message)
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			err = dp.sync(ctx, dockerClient)
+			if err != nil {
+				return err
+			}
+		case <-dp.resyncs():
+			err = dp.sync(ctx, dockerClient)
+			if err != nil {
+				return err
+			}
+		}
 	}
 }
 
-func (dp *DockerProvider) fetch() {
-	dockerClient, err := dp.clientFunc(dp.config)
+func (dp *DockerProvider) sync(ctx context.Context, dockerClient client.APIClient) error {
+	started := time.Now()
+	containers, err := dp.listContainers(ctx, dockerClient)
 	if err != nil {
-		dp.logger.Error("docker client", "error", err)
-		return
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), dp.config.Interval)
-	defer cancel()
+	apps := make(map[string]App)
+	for _, ct := range containers {
+		if app, ok := dp.toValidApp(ct); ok {
+			apps[ct.ID] = app
+		}
+	}
 
-	containers, err := dockerClient.ContainerList(ctx, container.ListOptions{
-		Size:    false,
-		All:     true,
-		Latest:  false,
-		Since:   "",
-		Before:  "",
-		Limit:   0,
-		Filters: filters.NewArgs(filters.Arg("label", simplydashEnable)),
-	})
+	dp.mutex.Lock()
+	changed := !reflect.DeepEqual(dp.appsByContainerId, apps)
+	dp.appsByContainerId = apps
+	dp.mutex.Unlock()
+	dp.synced(started)
+
+	if changed {
+		notifyUpdate(dp.ctx, dp.notificationChan, dp.id)
+	}
+	return nil
+}
+
+func (dp *DockerProvider) handleEvent(ctx context.Context, dockerClient client.APIClient, message events.Message) error {
+	containerId := message.Actor.ID
+	if message.Action == events.ActionDestroy {
+		dp.setApp(containerId, App{}, false)
+		return nil
+	}
+
+	containers, err := dp.listContainers(ctx, dockerClient, filters.Arg("id", containerId))
 	if err != nil {
-		dp.logger.Error("list containers", "error", err)
-		return
+		return err
 	}
 
-	apps := make([]App, 0)
 	for _, ct := range containers {
-		app := dp.containerToApp(ct)
-		errs := app.Validate()
-		if len(errs) > 0 {
-			dp.logger.Error("invalid app specification", "error", errors.Join(errs...))
-		} else {
-			apps = insertOrdered(apps, app)
+		if ct.ID == containerId {
+			app, ok := dp.toValidApp(ct)
+			dp.setApp(containerId, app, ok)
+			return nil
 		}
 	}
 
-	if !reflect.DeepEqual(dp.apps, apps) {
-		dp.apps = apps
-		dp.notificationChan <- dp.id
+	dp.setApp(containerId, App{}, false)
+	return nil
+}
+
+// setApp stores the app for the given container, or removes it if present is false.
+func (dp *DockerProvider) setApp(containerId string, app App, present bool) {
+	dp.mutex.Lock()
+	existing, exists := dp.appsByContainerId[containerId]
+	changed := exists != present || (present && !reflect.DeepEqual(existing, app))
+	if present {
+		dp.appsByContainerId[containerId] = app
+	} else {
+		delete(dp.appsByContainerId, containerId)
 	}
+	dp.mutex.Unlock()
+
+	if changed {
+		notifyUpdate(dp.ctx, dp.notificationChan, dp.id)
+	}
+}
+
+func (dp *DockerProvider) listContainers(
+	ctx context.Context,
+	dockerClient client.APIClient,
+	extraFilters ...filters.KeyValuePair,
+) ([]types.Container, error) {
+	ctx, cancel := context.WithTimeout(ctx, dp.config.Timeout)
+	defer cancel()
+
+	return dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(append(extraFilters, filters.Arg("label", simplydashEnable))...),
+	})
+}
+
+func (dp *DockerProvider) toValidApp(ct types.Container) (App, bool) {
+	app := dp.containerToApp(ct)
+	errs := app.Validate()
+	if len(errs) > 0 {
+		dp.logger.Error("invalid app specification", "containerId", ct.ID, "error", errors.Join(errs...))
+		return app, false
+	}
+	return app, true
 }
 
 func (dp *DockerProvider) containerToApp(container types.Container) App {
+	app := appFromLabels(container.Labels)
+	source := strings.ToLower(strings.TrimSpace(app.Healthcheck.Source))
+	if source == HealthSourceDocker || source == HealthSourceBoth {
+		app.Healthcheck.Health = containerHealth(container)
+	}
+	return app
+}
+
+// containerHealth maps the state of a container, and the result of its own health check if it has one,
+// to the health of the app.
+func containerHealth(container types.Container) AppHealth {
+	switch container.State {
+	case "running":
+		switch {
+		case strings.Contains(container.Status, "(unhealthy)"):
+			return Error
+		case strings.Contains(container.Status, "(health: starting)"):
+			return Unknown
+		}
+		return Healthy
+	case "restarting", "paused":
+		return Warning
+	case "created":
+		return Unknown
+	default:
+		// exited, dead and removing containers
+		return Error
+	}
+}
+
+// appFromLabels builds an app from simplydash.* key-value pairs, as found on docker labels
+// or kubernetes annotations.
+func appFromLabels(labels map[string]string) App {
 	return App{
-		Name:        container.Labels[simplydashName],
-		Description: container.Labels[simplydashDescription],
-		Link:        container.Labels[simplydashLink],
-		Icon:        container.Labels[simplydashIcon],
-		Group:       container.Labels[simplydashGroup],
+		Name:        labels[simplydashName],
+		Description: labels[simplydashDescription],
+		Link:        labels[simplydashLink],
+		Icon:        labels[simplydashIcon],
+		Group:       labels[simplydashGroup],
+		Tags:        tagsFromLabel(labels[simplydashTags]),
 		Healthcheck: AppHealthcheck{
-			Enabled:  boolFromLabel(container, simplydashHealthcheckEnable, DefaultEnableHealthcheck),
-			Health:   Unknown,
-			Interval: durationFromLabel(container, simplydashHealthcheckInterval, DefaultHealthcheckInterval),
-			Timeout:  durationFromLabel(container, simplydashHealthcheckTimeout, DefaultHealthcheckTimeout),
+			Headers:     headersFromLabels(labels, simplydashHealthcheckHeaders),
+			Link:        labels[simplydashHealthcheckLink],
+			Method:      labels[simplydashHealthcheckMethod],
+			Health:      Unknown,
+			StatusCodes: labels[simplydashHealthcheckStatusCodes],
+			BodyRegex:   labels[simplydashHealthcheckBodyRegex],
+			JSONPath:    labels[simplydashHealthcheckJSONPath],
+			JSONValue:   labels[simplydashHealthcheckJSONValue],
+			Type:        labels[simplydashHealthcheckType],
+			Source:      labels[simplydashHealthcheckSource],
+			DNSName:     labels[simplydashHealthcheckDNSName],
+			CertExpiryDays: intFromLabels(
+				labels, simplydashHealthcheckCertExpiryDays, DefaultHealthcheckCertExpiryDays,
+			),
+			Retries: intFromLabels(labels, simplydashHealthcheckRetries, 0),
+			FailureThreshold: intFromLabels(
+				labels, simplydashHealthcheckFailureThreshold, DefaultHealthcheckFailureThreshold,
+			),
+			SuccessThreshold: intFromLabels(
+				labels, simplydashHealthcheckSuccessThreshold, DefaultHealthcheckSuccessThreshold,
+			),
+			Interval:   durationFromLabels(labels, simplydashHealthcheckInterval, DefaultHealthcheckInterval),
+			Timeout:    durationFromLabels(labels, simplydashHealthcheckTimeout, DefaultHealthcheckTimeout),
+			MaxLatency: durationFromLabels(labels, simplydashHealthcheckMaxLatency, 0),
+			Enabled:    boolFromLabels(labels, simplydashHealthcheckEnable, DefaultEnableHealthcheck),
+			FollowRedirects: boolFromLabels(
+				labels, simplydashHealthcheckFollowRedirects, DefaultHealthcheckFollowRedirects,
+			),
 		},
 	}
 }
 
-func boolFromLabel(container types.Container, label string, defaultValue bool) bool {
-	stringVal, ok := container.Labels[label]
+// headersFromLabels collects the labels under prefix into headers, like prefix + "Authorization".
+func headersFromLabels(labels map[string]string, prefix string) map[string]string {
+	var headers map[string]string
+	for label, value := range labels {
+		name, ok := strings.CutPrefix(label, prefix)
+		if !ok || name == "" {
+			continue
+		}
+
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[name] = value
+	}
+	return headers
+}
+
+// tagsFromLabel splits a comma separated list of tags, like "media, streaming".
+func tagsFromLabel(value string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func boolFromLabels(labels map[string]string, label string, defaultValue bool) bool {
+	stringVal, ok := labels[label]
 	if !ok {
 		return defaultValue
 	}
@@ -166,15 +412,29 @@ func boolFromLabel(container types.Container, label string, defaultValue bool) b
 	return boolVal
 }
 
-func durationFromLabel(container types.Container, label string, defaultValue time.Duration) time.Duration {
-	stringVal, ok := container.Labels[label]
+func intFromLabels(labels map[string]string, label string, defaultValue int) int {
+	stringVal, ok := labels[label]
+	if !ok {
+		return defaultValue
+	}
+
+	intVal, err := strconv.Atoi(stringVal)
+	if err != nil {
+		slog.Error("invalid int value for label", "error", err, "label", label)
+		return defaultValue
+	}
+	return intVal
+}
+
+func durationFromLabels(labels map[string]string, label string, defaultValue time.Duration) time.Duration {
+	stringVal, ok := labels[label]
 	if !ok {
 		return defaultValue
 	}
 
 	durationVal, err := time.ParseDuration(stringVal)
 	if err != nil {
-		slog.Error("invalid bool value for label", "error", err, "label", label)
+		slog.Error("invalid duration value for label", "error", err, "label", label)
 		return defaultValue
 	}
 
@@ -1,60 +1,195 @@
 package internal
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_boolFromLabel(t *testing.T) {
-	container := types.Container{Labels: map[string]string{}}
+	labels := map[string]string{}
 
 	t.Run("returns default value when label is missing", func(t *testing.T) {
-		assert.Equal(t, false, boolFromLabel(container, "nonexistent_label", false))
-		assert.Equal(t, true, boolFromLabel(container, "nonexistent_label", true))
+		assert.Equal(t, false, boolFromLabels(labels, "nonexistent_label", false))
+		assert.Equal(t, true, boolFromLabels(labels, "nonexistent_label", true))
 	})
 
 	t.Run("returns default value when label is not a bool", func(t *testing.T) {
-		container.Labels["invalid_label"] = "not_a_bool"
-		assert.Equal(t, false, boolFromLabel(container, "invalid_label", false))
-		assert.Equal(t, true, boolFromLabel(container, "invalid_label", true))
+		labels["invalid_label"] = "not_a_bool"
+		assert.Equal(t, false, boolFromLabels(labels, "invalid_label", false))
+		assert.Equal(t, true, boolFromLabels(labels, "invalid_label", true))
 	})
 
 	t.Run("returns the bool value when label is a bool", func(t *testing.T) {
-		container.Labels["valid_label"] = "false"
-		assert.Equal(t, false, boolFromLabel(container, "valid_label", false))
-		container.Labels["valid_label"] = "true"
-		assert.Equal(t, true, boolFromLabel(container, "valie_label", true))
+		labels["valid_label"] = "false"
+		assert.Equal(t, false, boolFromLabels(labels, "valid_label", false))
+		labels["valid_label"] = "true"
+		assert.Equal(t, true, boolFromLabels(labels, "valie_label", true))
 	})
 }
 
 func Test_durationFromLabel(t *testing.T) {
-	// A mock container to use in testing.
-	container := types.Container{
-		Labels: map[string]string{},
-	}
+	labels := map[string]string{}
 
 	t.Run("returns default value when label is not present", func(t *testing.T) {
 		defaultValue := 10 * time.Second
-		assert.Equal(t, defaultValue, durationFromLabel(container, "nonexistent_label", defaultValue))
+		assert.Equal(t, defaultValue, durationFromLabels(labels, "nonexistent_label", defaultValue))
 	})
 
 	t.Run("returns default value when label's value is not correctly formatted", func(t *testing.T) {
 		defaultValue := 10 * time.Second
-		container.Labels["invalid_label"] = "this_is_not_a_duration"
-		assert.Equal(t, defaultValue, durationFromLabel(container, "invalid_label", defaultValue))
+		labels["invalid_label"] = "this_is_not_a_duration"
+		assert.Equal(t, defaultValue, durationFromLabels(labels, "invalid_label", defaultValue))
 	})
 
 	t.Run("returns default value when label's value is non-positive", func(t *testing.T) {
 		defaultValue := 10 * time.Second
-		container.Labels["nonpositive_label"] = "-5s"
-		assert.Equal(t, defaultValue, durationFromLabel(container, "nonpositive_label", defaultValue))
+		labels["nonpositive_label"] = "-5s"
+		assert.Equal(t, defaultValue, durationFromLabels(labels, "nonpositive_label", defaultValue))
 	})
 
 	t.Run("returns parsed value when label's value is correctly formatted and positive", func(t *testing.T) {
-		container.Labels["valid_label"] = "5s"
-		assert.Equal(t, 5*time.Second, durationFromLabel(container, "valid_label", 10*time.Second))
+		labels["valid_label"] = "5s"
+		assert.Equal(t, 5*time.Second, durationFromLabels(labels, "valid_label", 10*time.Second))
 	})
 }
+
+type fakeDockerClient struct {
+	client.APIClient
+	containers []types.Container
+	messages   chan events.Message
+	errs       chan error
+}
+
+func (f *fakeDockerClient) ContainerList(_ context.Context, options container.ListOptions) ([]types.Container, error) {
+	containers := make([]types.Container, 0)
+	for _, ct := range f.containers {
+		if ids := options.Filters.Get("id"); len(ids) > 0 && ids[0] != ct.ID {
+			continue
+		}
+		containers = append(containers, ct)
+	}
+	return containers, nil
+}
+
+func (f *fakeDockerClient) Events(_ context.Context, _ types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return f.messages, f.errs
+}
+
+func (f *fakeDockerClient) Close() error {
+	return nil
+}
+
+func testContainer(id string, name string) types.Container {
+	return types.Container{
+		ID: id,
+		Labels: map[string]string{
+			simplydashEnable: "true",
+			simplydashName:   name,
+			simplydashLink:   "https://" + name + ".example.com",
+			simplydashGroup:  "test",
+		},
+	}
+}
+
+func TestDockerProvider_events(t *testing.T) {
+	fake := &fakeDockerClient{
+		containers: []types.Container{testContainer("a", "alpha")},
+		messages:   make(chan events.Message),
+		errs:       make(chan error, 1),
+	}
+	notificationChan := make(chan string, 1)
+	provider := NewDockerProvider(context.Background(), "test", DockerProviderConfig{}, notificationChan).(*DockerProvider)
+	provider.clientFunc = func(DockerProviderConfig) (client.APIClient, error) { return fake, nil }
+
+	assert.NoError(t, provider.Init())
+
+	<-notificationChan
+	assert.Equal(t, []string{"alpha"}, appNames(provider.Apps()))
+
+	fake.containers = append(fake.containers, testContainer("b", "beta"))
+	fake.messages <- events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "b"}}
+	<-notificationChan
+	assert.Equal(t, []string{"alpha", "beta"}, appNames(provider.Apps()))
+
+	fake.messages <- events.Message{Action: events.ActionDestroy, Actor: events.Actor{ID: "a"}}
+	<-notificationChan
+	assert.Equal(t, []string{"beta"}, appNames(provider.Apps()))
+}
+
+func appNames(apps []App) []string {
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+func Test_appFromLabels_healthcheck(t *testing.T) {
+	app := appFromLabels(map[string]string{
+		"simplydash.healthcheck.link":                  "http://app:8080/health",
+		"simplydash.healthcheck.method":                "HEAD",
+		"simplydash.healthcheck.headers.Authorization": "Bearer token",
+		"simplydash.healthcheck.status_codes":          "200-299,401",
+		"simplydash.healthcheck.json_path":             "$.status",
+		"simplydash.healthcheck.json_value":            "up",
+		"simplydash.healthcheck.follow_redirects":      "false",
+		"simplydash.healthcheck.max_latency":           "500ms",
+	})
+
+	assert.Equal(t, "http://app:8080/health", app.Healthcheck.Link)
+	assert.Equal(t, "HEAD", app.Healthcheck.Method)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer token"}, app.Healthcheck.Headers)
+	assert.Equal(t, "200-299,401", app.Healthcheck.StatusCodes)
+	assert.Equal(t, "$.status", app.Healthcheck.JSONPath)
+	assert.Equal(t, "up", app.Healthcheck.JSONValue)
+	assert.False(t, app.Healthcheck.FollowRedirects)
+	assert.Equal(t, 500*time.Millisecond, app.Healthcheck.MaxLatency)
+}
+
+func Test_containerHealth(t *testing.T) {
+	tests := []struct {
+		state    string
+		status   string
+		expected AppHealth
+	}{
+		{state: "running", status: "Up 2 hours", expected: Healthy},
+		{state: "running", status: "Up 2 hours (healthy)", expected: Healthy},
+		{state: "running", status: "Up 10 seconds (health: starting)", expected: Unknown},
+		{state: "running", status: "Up 2 hours (unhealthy)", expected: Error},
+		{state: "restarting", status: "Restarting (1) 5 seconds ago", expected: Warning},
+		{state: "paused", status: "Up 2 hours (Paused)", expected: Warning},
+		{state: "created", status: "Created", expected: Unknown},
+		{state: "exited", status: "Exited (137) 1 minute ago", expected: Error},
+		{state: "dead", status: "Dead", expected: Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			assert.Equal(t, tt.expected, containerHealth(types.Container{State: tt.state, Status: tt.status}))
+		})
+	}
+}
+
+func TestDockerProvider_containerToApp_healthSource(t *testing.T) {
+	provider := NewDockerProvider(context.Background(), "test", DockerProviderConfig{}, nil).(*DockerProvider)
+	container := types.Container{
+		State:  "exited",
+		Labels: map[string]string{"simplydash.healthcheck.source": "docker"},
+	}
+	assert.Equal(t, Error, provider.containerToApp(container).Healthcheck.Health)
+
+	container.Labels["simplydash.healthcheck.source"] = "http"
+	assert.Equal(t, Unknown, provider.containerToApp(container).Healthcheck.Health)
+}
+
+func Test_tagsFromLabel(t *testing.T) {
+	assert.Equal(t, []string{"media", "streaming"}, tagsFromLabel(" media,streaming, ,"))
+	assert.Equal(t, []string{}, tagsFromLabel(""))
+	assert.Equal(t, []string{"arr"}, appFromLabels(map[string]string{simplydashTags: "arr"}).Tags)
+}
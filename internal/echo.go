@@ -1,26 +1,54 @@
 package internal
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-func SetupRouting(e *echo.Echo, websocketServer *WebsocketServer, imageService ImageService, config Config) {
+func SetupRouting(
+	ctx context.Context,
+	e *echo.Echo,
+	appService AppService,
+	broadcaster *Broadcaster,
+	websocketServer *WebsocketServer,
+	imageService ImageService,
+	auth *Auth,
+) {
 	e.Static("/", "./web/build")
 
-	e.GET("/ws", handleWebsocket(websocketServer))
+	e.GET("/health", getHealth)
+	e.GET("/ws", handleWebsocket(websocketServer, auth))
+	e.GET("/events", handleEvents(ctx, broadcaster, auth))
+	e.GET("/apps", getApps(appService))
 	e.GET("/image", getImage(imageService))
-	e.GET("/settings", getSettings(config))
+	e.GET("/settings", getSettings(appService))
+	e.GET("/providers", getProviderStatuses(appService))
+	e.GET("/metrics", echo.WrapHandler(metricsHandler(appService)))
+	setupAPI(e.Group("/api/v1"), appService)
 }
 
-func getSettings(config Config) func(c echo.Context) error {
+// getHealth tells that the server is up, for load balancers and uptime monitors.
+func getHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func getProviderStatuses(appService AppService) func(c echo.Context) error {
 	return func(c echo.Context) error {
-		err := c.JSON(http.StatusOK, config.App)
+		return c.JSON(http.StatusOK, appService.GetProviderStatuses())
+	}
+}
+
+func getSettings(appService AppService) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		err := c.JSON(http.StatusOK, appService.GetSettings())
 		if err != nil {
 			_ = c.NoContent(http.StatusInternalServerError)
 		}
@@ -28,9 +56,27 @@ func getSettings(config Config) func(c echo.Context) error {
 	}
 }
 
+// getApps serves the app groups as json, honoring If-None-Match so that remote instances can poll cheaply.
+func getApps(appService AppService) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		body, err := json.Marshal(appService.GetApps())
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+		c.Response().Header().Set("ETag", etag)
+		if c.Request().Header.Get("If-None-Match") == etag {
+			return c.NoContent(http.StatusNotModified)
+		}
+
+		return c.JSONBlob(http.StatusOK, body)
+	}
+}
+
 func getImage(imageService ImageService) func(c echo.Context) error {
 	return func(c echo.Context) error {
-		filePath, err := imageService.Get(c.QueryParam("url"))
+		filePath, err := imageService.Get(c.Request().Context(), c.QueryParam("url"))
 		if err != nil {
 			slog.Error("image not found", slog.Any("error", err))
 			return c.NoContent(http.StatusNotFound)
@@ -40,12 +86,10 @@ func getImage(imageService ImageService) func(c echo.Context) error {
 	}
 }
 
-func handleWebsocket(websocketServer *WebsocketServer) func(c echo.Context) error {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
+// handleWebsocket only upgrades requests from allowed origins, so that other sites can't open websockets
+// with the session of the user. The websocket is closed once the session ends.
+func handleWebsocket(websocketServer *WebsocketServer, auth *Auth) func(c echo.Context) error {
+	upgrader := websocket.Upgrader{CheckOrigin: auth.CheckOrigin}
 
 	return func(c echo.Context) error {
 		ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
@@ -54,19 +98,25 @@ func handleWebsocket(websocketServer *WebsocketServer) func(c echo.Context) erro
 			return err
 		}
 
-		websocketServer.Connect(time.Now().String(), ws)
+		websocketServer.Connect(auth.SessionContext(c.Request()), ws)
 		return nil
 	}
 }
 
-func CreateEcho(args Args) *echo.Echo {
+// CreateEcho creates the server with its middlewares, including the auth guarding all routes.
+func CreateEcho(args Args, auth *Auth) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc:  func(origin string) (bool, error) { return auth.allowsOrigin(origin), nil },
+		AllowCredentials: true,
+	}))
 	if args.AccessLogs {
 		e.Use(loggingFunc())
 	}
+	e.Use(auth.Middleware())
+	auth.setupRoutes(e)
 	return e
 }
 
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// handleEvents streams the events of the broadcaster as server-sent events, for clients which can't use websockets.
+// Clients reconnecting with Last-Event-ID only get the events they missed, and heartbeat comments keep proxies
+// from closing idle streams. The stream ends with the session it was opened with.
+func handleEvents(ctx context.Context, broadcaster *Broadcaster, auth *Auth) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		session := auth.SessionContext(c.Request())
+		subscription := broadcaster.Subscribe(broadcaster.ParseEventId(c.Request().Header.Get("Last-Event-ID")))
+		defer broadcaster.Unsubscribe(subscription)
+
+		response := c.Response()
+		response.Header().Set(echo.HeaderContentType, "text/event-stream")
+		response.Header().Set(echo.HeaderCacheControl, "no-cache")
+		response.Header().Set(echo.HeaderConnection, "keep-alive")
+		// nginx buffers responses by default, which holds events back
+		response.Header().Set("X-Accel-Buffering", "no")
+		response.WriteHeader(http.StatusOK)
+		response.Flush()
+
+		heartbeat := time.NewTicker(DefaultEventsHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-ctx.Done():
+				// the server waits for running requests when shutting down
+				return nil
+			case <-session.Done():
+				return nil
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+			case <-subscription.Notify():
+				for _, event := range subscription.Events() {
+					_, err := fmt.Fprintf(response, "id: %s\nevent: %s\ndata: %s\n\n",
+						broadcaster.EventId(event), event.Type, event.Data)
+					if err != nil {
+						return nil
+					}
+				}
+			}
+			response.Flush()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type serverSentEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// readEvent reads the next event of the stream, skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	t.Helper()
+	var event serverSentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "":
+			if event.event != "" {
+				return event
+			}
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			event.data = value
+		}
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	broadcaster, appService := newTestBroadcaster(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	auth, err := NewAuth(AuthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.GET("/events", handleEvents(ctx, broadcaster, auth))
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	connect := func(lastEventId string) (*http.Response, *bufio.Reader) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+		if lastEventId != "" {
+			request.Header.Set("Last-Event-ID", lastEventId)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response, bufio.NewReader(response.Body)
+	}
+
+	response, reader := connect("")
+	defer closeSafe(response.Body)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	settings := readEvent(t, reader)
+	assert.Equal(t, broadcastSettings, settings.event)
+	assert.JSONEq(t, `{"name": "dash", "groups": null}`, settings.data)
+	apps := readEvent(t, reader)
+	assert.Equal(t, broadcastApps, apps.event)
+	assert.Equal(t, "[]", apps.data)
+
+	appService.update([]AppGroup{NewAppGroup("media")}, AppConfig{})
+	update := readEvent(t, reader)
+	assert.Equal(t, broadcastApps, update.event)
+	assert.JSONEq(t, `[{"name": "media", "apps": []}]`, update.data)
+
+	resumed, resumedReader := connect(apps.id)
+	defer closeSafe(resumed.Body)
+	assert.Equal(t, update, readEvent(t, resumedReader), "resuming skips the events already received")
+
+	// the streams end when the server shuts down
+	cancel()
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+}
@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -19,35 +22,58 @@ type appConfig struct {
 	Group       string            `yaml:"group"`
 	Link        string            `yaml:"link"`
 	Icon        string            `yaml:"icon"`
+	Tags        []string          `yaml:"tags"`
 	Healthcheck healthcheckConfig `yaml:"healthcheck"`
 }
 
 type healthcheckConfig struct {
-	Link     string        `yaml:"link"`
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Enable   bool          `yaml:"enable"`
+	Headers          map[string]string `yaml:"headers"`
+	FollowRedirects  *bool             `yaml:"follow_redirects"`
+	Link             string            `yaml:"link"`
+	Method           string            `yaml:"method"`
+	StatusCodes      string            `yaml:"status_codes"`
+	BodyRegex        string            `yaml:"body_regex"`
+	JSONPath         string            `yaml:"json_path"`
+	JSONValue        string            `yaml:"json_value"`
+	Type             string            `yaml:"type"`
+	DNSName          string            `yaml:"dns_name"`
+	CertExpiryDays   int               `yaml:"cert_expiry_days"`
+	Retries          int               `yaml:"retries"`
+	FailureThreshold int               `yaml:"failure_threshold"`
+	SuccessThreshold int               `yaml:"success_threshold"`
+	Interval         time.Duration     `yaml:"interval"`
+	Timeout          time.Duration     `yaml:"timeout"`
+	MaxLatency       time.Duration     `yaml:"max_latency"`
+	Enable           bool              `yaml:"enable"`
 }
 
+// FileProviderConfig points to a single yaml file, a directory of yaml files, or a glob pattern of file names
+// within a directory, like /config/apps-*.yml.
 type FileProviderConfig struct {
 	Path string `yaml:"path" json:"path"`
 }
 
 type FileProvider struct {
-	watcher          *fsnotify.Watcher
+	*providerRuntime
 	logger           *slog.Logger
 	notificationChan chan<- string
 	id               string
 	path             string
-	apps             []App
+	pattern          string
+	// changeCh wakes up the goroutine parsing the files when the watcher sees a change
+	changeCh chan struct{}
+	apps     []App
+	mutex    sync.RWMutex
 }
 
-func NewFileProvider(name string, config FileProviderConfig, notificationChan chan<- string) Provider {
-	id := fmt.Sprintf("file-%s", name)
+func NewFileProvider(ctx context.Context, name string, config FileProviderConfig, notificationChan chan<- string) Provider {
+	id := providerId("file", name)
 	return &FileProvider{
+		providerRuntime:  newProviderRuntime(ctx, id),
 		id:               id,
 		path:             config.Path,
 		apps:             make([]App, 0),
+		changeCh:         make(chan struct{}, 1),
 		notificationChan: notificationChan,
 		logger:           slog.With("id", id),
 	}
@@ -58,6 +84,8 @@ func (fp *FileProvider) ID() string {
 }
 
 func (fp *FileProvider) Apps() []App {
+	fp.mutex.RLock()
+	defer fp.mutex.RUnlock()
 	return fp.apps
 }
 
@@ -68,83 +96,207 @@ func (fp *FileProvider) Init() error {
 	}
 	fp.path = absPath
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
+	// single files are watched through their parent directory, so that atomic saves are not missed
+	watchDir := filepath.Dir(absPath)
+	if isGlob(absPath) {
+		// the watcher only watches a single directory, so the directory can't be a pattern
+		if isGlob(watchDir) {
+			return fmt.Errorf("invalid path %s: wildcards are only supported in the file name", fp.path)
+		}
+		fp.pattern = absPath
+	} else if info, err := os.Stat(absPath); err == nil && info.IsDir() {
+		fp.pattern = filepath.Join(absPath, "*")
+		watchDir = absPath
 	}
 
-	fp.watcher = watcher
-	err = fp.watcher.Add(absPath)
+	err = newFileWatcher(fp.ctx, watchDir, fp.isRelevant, fp.changed, fp.logger).Start(fp.spawn)
 	if err != nil {
 		return err
 	}
 
-	go fp.parseFile()
-	go fp.watch()
+	fp.spawn(fp.run)
 	return nil
 }
 
-func (fp *FileProvider) watch() {
-	defer func(w *fsnotify.Watcher) {
-		_ = w.Close()
-	}(fp.watcher)
-
+// run parses the files initially, then on changes and on resync requests, for changes the watcher can't see like on
+// network mounts. Parsing only happens here, so that the apps of older files can't replace those of newer ones.
+func (fp *FileProvider) run() {
+	fp.parseFiles()
 	for {
 		select {
-		case err := <-fp.watcher.Errors:
-			fp.logger.Error("fsnotify", "error", err)
+		case <-fp.ctx.Done():
 			return
-		case event := <-fp.watcher.Events:
-			if event.Has(fsnotify.Write) {
-				fp.parseFile()
-			}
+		case <-fp.changeCh:
+			fp.parseFiles()
+		case <-fp.resyncs():
+			fp.parseFiles()
 		}
 	}
 }
 
-func (fp *FileProvider) parseFile() {
-	bytes, err := os.ReadFile(fp.path)
+// changed asks for the files to be parsed again, changes seen while they are being parsed are parsed right after.
+func (fp *FileProvider) changed() {
+	select {
+	case fp.changeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (fp *FileProvider) isRelevant(event fsnotify.Event) bool {
+	if isConfigMapFlip(event) {
+		return true
+	}
+
+	if fp.pattern == "" {
+		return filepath.Clean(event.Name) == fp.path
+	}
+	return fp.matches(event.Name)
+}
+
+// files returns the yaml files to load, in a stable order.
+func (fp *FileProvider) files() ([]string, error) {
+	if fp.pattern == "" {
+		return []string{fp.path}, nil
+	}
+
+	matches, err := filepath.Glob(fp.pattern)
 	if err != nil {
-		fp.logger.Error("reading file", "path", fp.path, "error", err)
-		return
+		return nil, err
 	}
 
-	appConfigs := make([]appConfig, 0)
-	err = yaml.Unmarshal(bytes, &appConfigs)
+	files := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() && fp.matches(match) {
+			files = append(files, match)
+		}
+	}
+	return files, nil
+}
+
+func (fp *FileProvider) matches(path string) bool {
+	ext := filepath.Ext(path)
+	if ext != ".yml" && ext != ".yaml" {
+		return false
+	}
+
+	matched, err := filepath.Match(fp.pattern, path)
+	return err == nil && matched
+}
+
+func (fp *FileProvider) parseFiles() {
+	started := time.Now()
+	files, err := fp.files()
 	if err != nil {
-		fp.logger.Error("parsing yaml", "path", fp.path, "error", err)
+		fp.logger.Error("listing files", "pattern", fp.pattern, "error", err)
+		fp.failed(err)
 		return
 	}
 
 	apps := make([]App, 0)
-	for _, appConfig := range appConfigs {
-		app := appConfig.toApp()
-		errs := app.Validate()
-		if len(errs) > 0 {
-			fp.logger.Error("invalid app config", "appConfig", appConfig, "error", errors.Join(errs...))
-		} else {
+	for _, file := range files {
+		fileApps, err := fp.parseFile(file)
+		if err != nil {
+			fp.logger.Error("parsing yaml", "path", file, "error", err)
+			fp.failed(fmt.Errorf("%s: %w", file, err))
+			return
+		}
+
+		for _, app := range fileApps {
 			apps = insertOrdered(apps, app)
 		}
 	}
 
-	if !reflect.DeepEqual(fp.apps, apps) {
-		fp.apps = apps
-		fp.notificationChan <- fp.id
+	fp.mutex.Lock()
+	changed := !reflect.DeepEqual(fp.apps, apps)
+	fp.apps = apps
+	fp.mutex.Unlock()
+	fp.synced(started)
+
+	if changed {
+		notifyUpdate(fp.ctx, fp.notificationChan, fp.id)
+	}
+}
+
+// parseFile decodes the apps of a single file, skipping invalid ones.
+// Apps are decoded node by node, so that errors can point to the line of the offending app.
+func (fp *FileProvider) parseFile(path string) ([]App, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(bytes, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]App, 0)
+	if len(document.Content) == 0 {
+		return apps, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of apps", root.Line)
 	}
+
+	for _, node := range root.Content {
+		var cfg appConfig
+		err = node.Decode(&cfg)
+		if err != nil {
+			fp.logger.Error("invalid app config", "path", path, "line", node.Line, "error", err)
+			continue
+		}
+
+		app := cfg.toApp()
+		errs := app.Validate()
+		if len(errs) > 0 {
+			fp.logger.Error("invalid app config", "path", path, "line", node.Line, "error", errors.Join(errs...))
+			continue
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
 }
 
 func (cfg appConfig) toApp() App {
+	followRedirects := DefaultHealthcheckFollowRedirects
+	if cfg.Healthcheck.FollowRedirects != nil {
+		followRedirects = *cfg.Healthcheck.FollowRedirects
+	}
+
 	return App{
 		Name:        cfg.Name,
 		Description: cfg.Description,
 		Link:        cfg.Link,
 		Icon:        cfg.Icon,
 		Group:       cfg.Group,
+		Tags:        cfg.Tags,
 		Healthcheck: AppHealthcheck{
-			Health:   Unknown,
-			Enabled:  cfg.Healthcheck.Enable,
-			Interval: cfg.Healthcheck.Interval,
-			Timeout:  cfg.Healthcheck.Timeout,
+			Headers:          cfg.Healthcheck.Headers,
+			Link:             cfg.Healthcheck.Link,
+			Method:           cfg.Healthcheck.Method,
+			Health:           Unknown,
+			StatusCodes:      cfg.Healthcheck.StatusCodes,
+			BodyRegex:        cfg.Healthcheck.BodyRegex,
+			JSONPath:         cfg.Healthcheck.JSONPath,
+			JSONValue:        cfg.Healthcheck.JSONValue,
+			Type:             cfg.Healthcheck.Type,
+			DNSName:          cfg.Healthcheck.DNSName,
+			CertExpiryDays:   cfg.Healthcheck.CertExpiryDays,
+			Retries:          cfg.Healthcheck.Retries,
+			FailureThreshold: cfg.Healthcheck.FailureThreshold,
+			SuccessThreshold: cfg.Healthcheck.SuccessThreshold,
+			Interval:         cfg.Healthcheck.Interval,
+			Timeout:          cfg.Healthcheck.Timeout,
+			MaxLatency:       cfg.Healthcheck.MaxLatency,
+			Enabled:          cfg.Healthcheck.Enable,
+			FollowRedirects:  followRedirects,
 		},
 	}
 }
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForNotification(t *testing.T, notificationChan <-chan string) {
+	t.Helper()
+	select {
+	case <-notificationChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for notification")
+	}
+}
+
+func TestFileProvider_directory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "media.yml"), `
+- name: Plex
+  link: https://plex.example.com
+  group: Media
+- name: Broken
+  group: Media
+`)
+	writeFile(t, filepath.Join(dir, "ops.yaml"), `
+- name: Grafana
+  link: https://grafana.example.com
+  group: Ops
+`)
+	writeFile(t, filepath.Join(dir, "notes.txt"), "not yaml")
+
+	notificationChan := make(chan string, 1)
+	provider := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: dir}, notificationChan)
+	assert.NoError(t, provider.Init())
+
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"Grafana", "Plex"}, appNames(provider.Apps()))
+
+	writeFile(t, filepath.Join(dir, "home.yml"), `
+- name: Home Assistant
+  link: https://ha.example.com
+  group: Home
+`)
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"Grafana", "Home Assistant", "Plex"}, appNames(provider.Apps()))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "ops.yaml")))
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"Home Assistant", "Plex"}, appNames(provider.Apps()))
+}
+
+func TestFileProvider_glob(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "team-a.yml"), "- {name: A, link: https://a.example.com, group: A}")
+	writeFile(t, filepath.Join(dir, "other.yml"), "- {name: B, link: https://b.example.com, group: B}")
+
+	provider := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: filepath.Join(dir, "team-*.yml")}, make(chan string, 1))
+	assert.NoError(t, provider.Init())
+
+	fp := provider.(*FileProvider)
+	files, err := fp.files()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "team-a.yml")}, files)
+}
+
+func TestFileProvider_globDirectory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "*", "apps.yml")
+
+	provider := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: path}, make(chan string, 1))
+	assert.EqualError(t, provider.Init(), "invalid path "+path+": wildcards are only supported in the file name")
+}
+
+func TestFileProvider_parseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apps.yml")
+	writeFile(t, path, "name: not a list")
+
+	fp := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: path}, make(chan string, 1)).(*FileProvider)
+	_, err := fp.parseFile(path)
+	assert.EqualError(t, err, "line 1: expected a list of apps")
+}
+
+func TestFileProvider_atomicSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "apps.yml")
+	writeFile(t, path, "- {name: A, link: https://a.example.com, group: A}")
+
+	notificationChan := make(chan string, 1)
+	provider := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: path}, notificationChan)
+	assert.NoError(t, provider.Init())
+
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"A"}, appNames(provider.Apps()))
+
+	// editors like vim write a temporary file and rename it over the original
+	tmpPath := filepath.Join(dir, ".apps.yml.swp")
+	writeFile(t, tmpPath, "- {name: B, link: https://b.example.com, group: B}")
+	assert.NoError(t, os.Rename(tmpPath, path))
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"B"}, appNames(provider.Apps()))
+
+	// further changes must still be picked up after the rename
+	writeFile(t, path, "- {name: C, link: https://c.example.com, group: C}")
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"C"}, appNames(provider.Apps()))
+}
+
+func TestFileProvider_configMapSymlinkFlip(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "v1.yml"), "- {name: A, link: https://a.example.com, group: A}")
+	writeFile(t, filepath.Join(dir, "v2.yml"), "- {name: B, link: https://b.example.com, group: B}")
+	assert.NoError(t, os.Symlink("v1.yml", filepath.Join(dir, configMapDataLink)))
+	assert.NoError(t, os.Symlink(configMapDataLink, filepath.Join(dir, "apps")))
+
+	notificationChan := make(chan string, 1)
+	provider := NewFileProvider(context.Background(), "test", FileProviderConfig{Path: filepath.Join(dir, "apps")}, notificationChan)
+	assert.NoError(t, provider.Init())
+
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"A"}, appNames(provider.Apps()))
+
+	assert.NoError(t, os.Symlink("v2.yml", filepath.Join(dir, "..data_tmp")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, configMapDataLink)))
+	waitForNotification(t, notificationChan)
+	assert.Equal(t, []string{"B"}, appNames(provider.Apps()))
+}
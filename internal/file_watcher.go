@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configMapDataLink is the symlink kubernetes flips atomically when a mounted ConfigMap is updated.
+const configMapDataLink = "..data"
+
+// fileWatcher watches a directory rather than individual files, so that files replaced by
+// atomic saves (rename or symlink swap) keep being watched. Bursts of matching events are
+// debounced into a single onChange call, and the watcher is re-established after errors.
+// The watcher stops when ctx is cancelled.
+type fileWatcher struct {
+	ctx      context.Context
+	matches  func(event fsnotify.Event) bool
+	onChange func()
+	logger   *slog.Logger
+	dir      string
+	debounce time.Duration
+}
+
+func newFileWatcher(
+	ctx context.Context,
+	dir string,
+	matches func(event fsnotify.Event) bool,
+	onChange func(),
+	logger *slog.Logger,
+) *fileWatcher {
+	return &fileWatcher{
+		ctx:      ctx,
+		dir:      dir,
+		matches:  matches,
+		onChange: onChange,
+		debounce: DefaultFileWatchDebounce,
+		logger:   logger,
+	}
+}
+
+// Start fails if the directory can't be watched initially, later failures are retried in the background.
+// The watcher runs in the goroutine started by spawn, so that owners can wait for it to stop.
+func (fw *fileWatcher) Start(spawn func(fn func())) error {
+	watcher, err := fw.newWatcher()
+	if err != nil {
+		return err
+	}
+
+	spawn(func() { fw.run(watcher) })
+	return nil
+}
+
+func (fw *fileWatcher) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = watcher.Add(fw.dir)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+func (fw *fileWatcher) run(watcher *fsnotify.Watcher) {
+	timer := time.NewTimer(fw.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-fw.ctx.Done():
+			_ = watcher.Close()
+			return
+		case <-timer.C:
+			fw.onChange()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				watcher = fw.rewatch(watcher, nil)
+				continue
+			}
+
+			if fw.matches(event) {
+				fw.logger.Debug("file event", "event", event)
+				timer.Reset(fw.debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				err = nil
+			}
+			watcher = fw.rewatch(watcher, err)
+		}
+
+		if watcher == nil {
+			return
+		}
+	}
+}
+
+// rewatch replaces a broken watcher, retrying with backoff until it succeeds or the watcher is closed.
+// Changes may have been missed in the meantime, so onChange is always called afterwards.
+func (fw *fileWatcher) rewatch(watcher *fsnotify.Watcher, cause error) *fsnotify.Watcher {
+	fw.logger.Error("file watcher failed, re-establishing", "dir", fw.dir, "error", cause)
+	_ = watcher.Close()
+
+	backoff := DefaultFileWatchMinBackoff
+	for {
+		select {
+		case <-fw.ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		newWatcher, err := fw.newWatcher()
+		if err == nil {
+			fw.onChange()
+			return newWatcher
+		}
+
+		fw.logger.Error("re-establishing file watcher", "dir", fw.dir, "error", err, "retryIn", backoff)
+		backoff = min(2*backoff, DefaultFileWatchMaxBackoff)
+	}
+}
+
+func isConfigMapFlip(event fsnotify.Event) bool {
+	return filepath.Base(event.Name) == configMapDataLink
+}
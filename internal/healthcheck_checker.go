@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	HealthcheckHTTP = "http"
+	HealthcheckTCP  = "tcp"
+	HealthcheckDNS  = "dns"
+	HealthcheckTLS  = "tls"
+)
+
+// Checker probes the target of a single health check. Check returns Healthy and no error,
+// or the health of the target with an error explaining it. The context carries the timeout of the check.
+type Checker interface {
+	Check(ctx context.Context) (AppHealth, error)
+}
+
+// CheckerFactory builds the checker of a health check, failing if its config is invalid for the type.
+type CheckerFactory func(healthcheck AppHealthcheck) (Checker, error)
+
+var checkerFactories = map[string]CheckerFactory{
+	HealthcheckHTTP: newHTTPChecker,
+	HealthcheckTCP:  newTCPChecker,
+	HealthcheckDNS:  newDNSChecker,
+	HealthcheckTLS:  newTLSChecker,
+}
+
+// RegisterChecker adds a health check type, or replaces an existing one.
+// It is not safe for concurrent use, and must be called before any health check is started.
+func RegisterChecker(checkType string, factory CheckerFactory) {
+	checkerFactories[checkType] = factory
+}
+
+func newChecker(healthcheck AppHealthcheck) (Checker, error) {
+	factory, ok := checkerFactories[healthcheck.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported healthcheck type %s", healthcheck.Type)
+	}
+	return factory(healthcheck)
+}
+
+func isTimeout(err error) bool {
+	return os.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// tcpChecker succeeds if a connection to host:port can be established.
+type tcpChecker struct {
+	address string
+}
+
+func newTCPChecker(healthcheck AppHealthcheck) (Checker, error) {
+	address, err := hostPort(healthcheck.Link, "")
+	if err != nil {
+		return nil, err
+	}
+	return &tcpChecker{address: address}, nil
+}
+
+func (c *tcpChecker) Check(ctx context.Context) (AppHealth, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return Error, err
+	}
+	closeSafe(conn)
+	return Healthy, nil
+}
+
+// dnsChecker resolves a name against the server of the health check.
+type dnsChecker struct {
+	resolver *net.Resolver
+	name     string
+}
+
+func newDNSChecker(healthcheck AppHealthcheck) (Checker, error) {
+	server, err := hostPort(healthcheck.Link, "53")
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(healthcheck.DNSName) == "" {
+		return nil, errors.New("dns name is required")
+	}
+
+	return &dnsChecker{
+		name: healthcheck.DNSName,
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		},
+	}, nil
+}
+
+func (c *dnsChecker) Check(ctx context.Context) (AppHealth, error) {
+	addresses, err := c.resolver.LookupHost(ctx, c.name)
+	if err != nil {
+		return Error, err
+	}
+
+	if len(addresses) == 0 {
+		return Error, fmt.Errorf("no addresses for %s", c.name)
+	}
+	return Healthy, nil
+}
+
+// tlsChecker completes a TLS handshake, and warns about certificates expiring soon.
+type tlsChecker struct {
+	config     *tls.Config
+	address    string
+	expiryDays int
+}
+
+func newTLSChecker(healthcheck AppHealthcheck) (Checker, error) {
+	address, err := hostPort(healthcheck.Link, "443")
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+	return &tlsChecker{
+		config:     &tls.Config{ServerName: host},
+		address:    address,
+		expiryDays: healthcheck.CertExpiryDays,
+	}, nil
+}
+
+func (c *tlsChecker) Check(ctx context.Context) (AppHealth, error) {
+	dialer := tls.Dialer{Config: c.config}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return Error, err
+	}
+	defer closeSafe(conn)
+
+	certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return Error, errors.New("no certificate presented")
+	}
+
+	expiresIn := time.Until(certificates[0].NotAfter)
+	if expiresIn < time.Duration(c.expiryDays)*24*time.Hour {
+		return Warning, fmt.Errorf("certificate expires in %s", expiresIn.Round(time.Hour))
+	}
+	return Healthy, nil
+}
+
+// schemePorts are the ports of urls without one, for the checks defaulting to the link of the app.
+var schemePorts = map[string]string{"http": "80", "https": "443"}
+
+// hostPort extracts host:port from a url or an address, adding defaultPort if the port is missing.
+// Without a default port, the port of the scheme of the url is used, and the port is required otherwise.
+func hostPort(link string, defaultPort string) (string, error) {
+	address := strings.TrimSpace(link)
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", err
+		}
+		address = u.Host
+		if defaultPort == "" {
+			defaultPort = schemePorts[strings.ToLower(u.Scheme)]
+		}
+	}
+
+	if address == "" {
+		return "", fmt.Errorf("invalid address %q", link)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address, nil
+	}
+
+	if defaultPort == "" {
+		return "", fmt.Errorf("port is required in %q", link)
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), defaultPort), nil
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func checkHealth(t *testing.T, healthcheck AppHealthcheck) (AppHealth, error) {
+	t.Helper()
+	healthcheck.Enabled = true
+	if errs := healthcheck.Validate(); !assert.Empty(t, errs) {
+		return Unknown, nil
+	}
+
+	checker, err := newChecker(healthcheck)
+	if !assert.NoError(t, err) {
+		return Unknown, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return checker.Check(ctx)
+}
+
+func TestTCPChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	address := listener.Addr().String()
+
+	health, err := checkHealth(t, AppHealthcheck{Type: HealthcheckTCP, Link: "tcp://" + address})
+	assert.NoError(t, err)
+	assert.Equal(t, Healthy, health)
+
+	closeSafe(listener)
+	health, err = checkHealth(t, AppHealthcheck{Type: HealthcheckTCP, Link: address})
+	assert.Error(t, err)
+	assert.Equal(t, Error, health)
+
+	errs := (&AppHealthcheck{Enabled: true, Type: HealthcheckTCP, Link: "127.0.0.1"}).Validate()
+	assert.NotEmpty(t, errs)
+
+	checker, err := newTCPChecker(AppHealthcheck{Link: "https://app.example.com/login"})
+	assert.NoError(t, err, "the port of the link of the app defaults from its scheme")
+	assert.Equal(t, "app.example.com:443", checker.(*tcpChecker).address)
+}
+
+func TestTLSChecker(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	check := func(expiryDays int) (AppHealth, error) {
+		checker, err := newTLSChecker(AppHealthcheck{Link: server.URL, CertExpiryDays: expiryDays})
+		if !assert.NoError(t, err) {
+			return Unknown, nil
+		}
+		checker.(*tlsChecker).config.RootCAs = roots
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return checker.Check(ctx)
+	}
+
+	health, err := check(14)
+	assert.NoError(t, err)
+	assert.Equal(t, Healthy, health)
+
+	expiresIn := time.Until(server.Certificate().NotAfter)
+	health, err = check(int(expiresIn.Hours()/24) + 1)
+	assert.Error(t, err)
+	assert.Equal(t, Warning, health)
+
+	// the certificate of the test server isn't trusted by default
+	health, err = checkHealth(t, AppHealthcheck{Type: HealthcheckTLS, Link: server.URL})
+	assert.Error(t, err)
+	assert.Equal(t, Error, health)
+}
+
+func TestDNSChecker(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer closeSafe(conn)
+	go serveDNS(conn, "app.home.arpa.", [4]byte{10, 0, 0, 1})
+
+	health, err := checkHealth(t, AppHealthcheck{
+		Type:    HealthcheckDNS,
+		Link:    conn.LocalAddr().String(),
+		DNSName: "app.home.arpa",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Healthy, health)
+
+	health, err = checkHealth(t, AppHealthcheck{
+		Type:    HealthcheckDNS,
+		Link:    conn.LocalAddr().String(),
+		DNSName: "missing.home.arpa",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, Error, health)
+
+	errs := (&AppHealthcheck{Enabled: true, Type: HealthcheckDNS, Link: "127.0.0.1"}).Validate()
+	assert.NotEmpty(t, errs)
+}
+
+// serveDNS answers A queries for name with address, and every other query with NXDOMAIN.
+func serveDNS(conn net.PacketConn, name string, address [4]byte) {
+	buffer := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		var query dnsmessage.Message
+		if err := query.Unpack(buffer[:n]); err != nil || len(query.Questions) == 0 {
+			continue
+		}
+
+		question := query.Questions[0]
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+			Questions: query.Questions,
+		}
+
+		switch {
+		case question.Name.String() != name:
+			response.RCode = dnsmessage.RCodeNameError
+		case question.Type == dnsmessage.TypeA:
+			response.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.AResource{A: address},
+			}}
+		}
+
+		packed, err := response.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteTo(packed, addr)
+	}
+}
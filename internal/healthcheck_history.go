@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HealthResult is the outcome of a single health check.
+type HealthResult struct {
+	Time    time.Time     `json:"time"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Health  AppHealth     `json:"health"`
+}
+
+// HealthSummary condenses the history of a health check for clients.
+type HealthSummary struct {
+	LastChecked *time.Time `json:"last_checked"`
+	Uptime24h   *float64   `json:"uptime_24h"`
+	Uptime7d    *float64   `json:"uptime_7d"`
+	LastError   string     `json:"last_error"`
+	// Latencies are the latencies of the latest checks in milliseconds, from the oldest to the newest.
+	Latencies []float64 `json:"latencies"`
+}
+
+// uptimeBucket counts the checks of an hour, identified by the unix time of its start.
+type uptimeBucket struct {
+	Hour    int64 `json:"hour"`
+	Total   int   `json:"total"`
+	Healthy int   `json:"healthy"`
+}
+
+// healthHistory keeps the latest results in a ring, and hourly buckets covering DefaultHealthHistoryUptimeWindow
+// for uptime. Both are bounded, however long the health check runs. It is not safe for concurrent use.
+type healthHistory struct {
+	results   []HealthResult
+	buckets   []uptimeBucket
+	lastError string
+	next      int
+	count     int
+}
+
+// healthHistorySnapshot is the persisted form of a history, with the results from the oldest to the newest.
+type healthHistorySnapshot struct {
+	LastError string         `json:"last_error"`
+	Results   []HealthResult `json:"results"`
+	Buckets   []uptimeBucket `json:"buckets"`
+}
+
+func newHealthHistory() *healthHistory {
+	return &healthHistory{
+		results: make([]HealthResult, DefaultHealthHistorySize),
+		buckets: make([]uptimeBucket, int(DefaultHealthHistoryUptimeWindow/time.Hour)),
+	}
+}
+
+func (h *healthHistory) record(result HealthResult) {
+	h.results[h.next] = result
+	h.next = (h.next + 1) % len(h.results)
+	h.count = min(h.count+1, len(h.results))
+
+	if result.Error != "" {
+		h.lastError = result.Error
+	}
+
+	if result.Health == Unknown {
+		return
+	}
+
+	hour := result.Time.Truncate(time.Hour).Unix()
+	bucket := &h.buckets[(hour/3600)%int64(len(h.buckets))]
+	if bucket.Hour != hour {
+		*bucket = uptimeBucket{Hour: hour}
+	}
+
+	bucket.Total++
+	if isUp(result.Health) {
+		bucket.Healthy++
+	}
+}
+
+// ordered returns the results from the oldest to the newest.
+func (h *healthHistory) ordered() []HealthResult {
+	results := make([]HealthResult, 0, h.count)
+	for i := range h.count {
+		results = append(results, h.results[(h.next-h.count+i+len(h.results))%len(h.results)])
+	}
+	return results
+}
+
+func (h *healthHistory) summary(now time.Time) HealthSummary {
+	summary := HealthSummary{
+		LastError: h.lastError,
+		Uptime24h: h.uptime(now, 24*time.Hour),
+		Uptime7d:  h.uptime(now, 7*24*time.Hour),
+		Latencies: make([]float64, 0, DefaultHealthLatencySeriesSize),
+	}
+
+	results := h.ordered()
+	if len(results) > 0 {
+		summary.LastChecked = &results[len(results)-1].Time
+	}
+
+	for _, result := range results[max(0, len(results)-DefaultHealthLatencySeriesSize):] {
+		summary.Latencies = append(summary.Latencies, float64(result.Latency.Microseconds())/1000)
+	}
+	return summary
+}
+
+// uptime returns the percentage of checks in the window which were up, or nil without any checks.
+// The window is made of whole hours, ending with the current one.
+func (h *healthHistory) uptime(now time.Time, window time.Duration) *float64 {
+	since := now.Truncate(time.Hour).Add(time.Hour - window).Unix()
+
+	total, healthy := 0, 0
+	for _, bucket := range h.buckets {
+		if bucket.Total > 0 && bucket.Hour >= since && bucket.Hour <= now.Unix() {
+			total += bucket.Total
+			healthy += bucket.Healthy
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+	uptime := 100 * float64(healthy) / float64(total)
+	return &uptime
+}
+
+func (h *healthHistory) snapshot() healthHistorySnapshot {
+	buckets := make([]uptimeBucket, 0, len(h.buckets))
+	for _, bucket := range h.buckets {
+		if bucket.Total > 0 {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return healthHistorySnapshot{LastError: h.lastError, Results: h.ordered(), Buckets: buckets}
+}
+
+func (h *healthHistory) restore(snapshot healthHistorySnapshot) {
+	for _, result := range snapshot.Results[max(0, len(snapshot.Results)-len(h.results)):] {
+		h.results[h.next] = result
+		h.next = (h.next + 1) % len(h.results)
+		h.count = min(h.count+1, len(h.results))
+	}
+
+	for _, bucket := range snapshot.Buckets {
+		h.buckets[(bucket.Hour/3600)%int64(len(h.buckets))] = bucket
+	}
+	h.lastError = snapshot.LastError
+}
+
+// isUp counts warnings as up: the app responds, if not perfectly.
+func isUp(health AppHealth) bool {
+	return health == Healthy || health == Warning
+}
+
+func loadHealthHistories(path string) (map[string]healthHistorySnapshot, error) {
+	histories := make(map[string]healthHistorySnapshot)
+
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return histories, nil
+	}
+	if err != nil {
+		return histories, err
+	}
+
+	err = json.Unmarshal(bytes, &histories)
+	return histories, err
+}
+
+// saveHealthHistories replaces the file atomically, so that a crash while saving keeps the previous histories.
+func saveHealthHistories(path string, histories map[string]healthHistorySnapshot) error {
+	bytes, err := json.Marshal(histories)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(bytes)
+	closeSafe(file)
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
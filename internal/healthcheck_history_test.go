@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHistory_ring(t *testing.T) {
+	history := newHealthHistory()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range DefaultHealthHistorySize + 10 {
+		history.record(HealthResult{Time: start.Add(time.Duration(i) * time.Second), Health: Healthy})
+	}
+
+	results := history.ordered()
+	assert.Len(t, results, DefaultHealthHistorySize)
+	assert.Equal(t, start.Add(10*time.Second), results[0].Time)
+	assert.Equal(t, start.Add(time.Duration(DefaultHealthHistorySize+9)*time.Second), results[len(results)-1].Time)
+}
+
+func TestHealthHistory_summary(t *testing.T) {
+	now := time.Date(2024, 1, 8, 12, 30, 0, 0, time.UTC)
+	history := newHealthHistory()
+
+	summary := history.summary(now)
+	assert.Nil(t, summary.LastChecked)
+	assert.Nil(t, summary.Uptime24h)
+	assert.Nil(t, summary.Uptime7d)
+	assert.Empty(t, summary.Latencies)
+
+	// an hourly check over the last week, down for a whole day 3 days ago
+	for hour := range 7 * 24 {
+		checkedAt := now.Add(-time.Duration(7*24-hour) * time.Hour)
+		health := Healthy
+		if hour >= 4*24 && hour < 5*24 {
+			health = Error
+		}
+		history.record(HealthResult{Time: checkedAt, Health: health, Latency: 10 * time.Millisecond})
+	}
+	history.record(HealthResult{Time: now, Health: Warning, Latency: 1500 * time.Microsecond, Error: "slow"})
+	history.record(HealthResult{Time: now, Health: Unknown})
+
+	summary = history.summary(now)
+	assert.Equal(t, now, *summary.LastChecked)
+	assert.Equal(t, "slow", summary.LastError)
+	assert.Equal(t, 100.0, *summary.Uptime24h)
+	assert.InDelta(t, 100*144.0/168.0, *summary.Uptime7d, 0.01)
+	assert.Len(t, summary.Latencies, DefaultHealthLatencySeriesSize)
+	assert.Equal(t, []float64{10, 1.5, 0}, summary.Latencies[DefaultHealthLatencySeriesSize-3:])
+}
+
+func TestHealthcheckService_persistHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	historyFile := filepath.Join(t.TempDir(), "data", "history.json")
+	healthcheck := AppHealthcheck{Link: server.URL, Enabled: true, Interval: time.Hour, Timeout: time.Second}
+	assert.Empty(t, healthcheck.Validate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := NewHealthcheckService(ctx, historyFile)
+	svc.Init()
+	svc.Add("app", healthcheck)
+	assert.Eventually(t, func() bool { return svc.Summary("app").LastChecked != nil }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, svc.Shutdown(context.Background()))
+
+	histories, err := loadHealthHistories(historyFile)
+	assert.NoError(t, err)
+	assert.Len(t, histories["app"].Results, 1)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	svc = NewHealthcheckService(ctx, historyFile)
+	svc.Init()
+	svc.Add("app", healthcheck)
+
+	summary := svc.Summary("app")
+	assert.NotNil(t, summary.LastChecked)
+	assert.Equal(t, "got status code 503", summary.LastError)
+	assert.Equal(t, 0.0, *summary.Uptime24h)
+}
+
+func Test_loadHealthHistories_missingFile(t *testing.T) {
+	histories, err := loadHealthHistories(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Empty(t, histories)
+
+	_, err = loadHealthHistories(t.TempDir())
+	assert.Error(t, err)
+}
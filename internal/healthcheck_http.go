@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var healthcheckMethods = []string{http.MethodHead, http.MethodGet, http.MethodPost}
+
+// httpChecker sends a request to the link of the health check, and evaluates the response with its criteria.
+type httpChecker struct {
+	client   *http.Client
+	criteria healthcheckCriteria
+	config   AppHealthcheck
+}
+
+func newHTTPChecker(healthcheck AppHealthcheck) (Checker, error) {
+	if !isHealthcheckMethod(healthcheck.Method) {
+		return nil, fmt.Errorf("unsupported healthcheck method %s", healthcheck.Method)
+	}
+
+	// responses to HEAD requests have no body, so body criteria would always fail
+	if healthcheck.Method == http.MethodHead && (healthcheck.BodyRegex != "" || healthcheck.JSONPath != "") {
+		return nil, errors.New("body_regex and json_path can't be used with the HEAD method")
+	}
+
+	criteria, err := newHealthcheckCriteria(healthcheck)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{}
+	if !healthcheck.FollowRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return &httpChecker{client: client, criteria: criteria, config: healthcheck}, nil
+}
+
+func (c *httpChecker) Check(ctx context.Context) (AppHealth, error) {
+	request, err := http.NewRequestWithContext(ctx, c.config.Method, c.config.Link, nil)
+	if err != nil {
+		return Error, err
+	}
+
+	for name, value := range c.config.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			request.Host = value
+			continue
+		}
+		request.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	response, err := c.client.Do(request)
+	if err != nil {
+		return Error, err
+	}
+	latency := time.Since(start)
+	defer closeSafe(response.Body)
+
+	var body []byte
+	if c.criteria.needsBody() {
+		body, err = io.ReadAll(io.LimitReader(response.Body, DefaultHealthcheckMaxBodySize))
+		if err != nil {
+			return Error, fmt.Errorf("reading body: %w", err)
+		}
+	}
+
+	health, reason := c.criteria.evaluate(response.StatusCode, body, latency)
+	if health != Healthy {
+		return health, errors.New(reason)
+	}
+	return Healthy, nil
+}
+
+// statusCodeRange is an inclusive range of accepted status codes.
+type statusCodeRange struct {
+	from int
+	to   int
+}
+
+// healthcheckCriteria decides the health of a response, compiled from the config of a health check.
+type healthcheckCriteria struct {
+	bodyRegex   *regexp.Regexp
+	statusCodes []statusCodeRange
+	jsonPath    []any
+	jsonValue   string
+	maxLatency  time.Duration
+}
+
+func newHealthcheckCriteria(healthcheck AppHealthcheck) (healthcheckCriteria, error) {
+	criteria := healthcheckCriteria{
+		jsonValue:  healthcheck.JSONValue,
+		maxLatency: healthcheck.MaxLatency,
+	}
+
+	var err error
+	if criteria.statusCodes, err = parseStatusCodes(healthcheck.StatusCodes); err != nil {
+		return criteria, fmt.Errorf("invalid status codes: %w", err)
+	}
+
+	if criteria.bodyRegex, err = compileOptionalRegex(healthcheck.BodyRegex); err != nil {
+		return criteria, fmt.Errorf("invalid body regex: %w", err)
+	}
+
+	if healthcheck.JSONPath != "" {
+		if criteria.jsonPath, err = parseJSONPath(healthcheck.JSONPath); err != nil {
+			return criteria, fmt.Errorf("invalid json path: %w", err)
+		}
+	}
+
+	return criteria, nil
+}
+
+func (c healthcheckCriteria) needsBody() bool {
+	return c.bodyRegex != nil || c.jsonPath != nil
+}
+
+// evaluate returns the health of a response, and the reason why it isn't healthy.
+func (c healthcheckCriteria) evaluate(statusCode int, body []byte, latency time.Duration) (AppHealth, string) {
+	if health := c.evaluateStatusCode(statusCode); health != Healthy {
+		return health, fmt.Sprintf("got status code %d", statusCode)
+	}
+
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return Error, fmt.Sprintf("body does not match %s", c.bodyRegex)
+	}
+
+	if c.jsonPath != nil {
+		if err := c.evaluateJSON(body); err != nil {
+			return Error, err.Error()
+		}
+	}
+
+	if c.maxLatency > 0 && latency > c.maxLatency {
+		return Warning, fmt.Sprintf("latency %s exceeds %s", latency, c.maxLatency)
+	}
+
+	return Healthy, ""
+}
+
+// evaluateStatusCode accepts the configured status codes only. Without any configured,
+// 404 and 5xx are errors, other 4xx are warnings and everything else is healthy.
+func (c healthcheckCriteria) evaluateStatusCode(statusCode int) AppHealth {
+	if len(c.statusCodes) > 0 {
+		for _, statusCodes := range c.statusCodes {
+			if statusCode >= statusCodes.from && statusCode <= statusCodes.to {
+				return Healthy
+			}
+		}
+		return Error
+	}
+
+	if statusCode == http.StatusNotFound || statusCode >= 500 {
+		return Error
+	}
+
+	if statusCode >= 400 {
+		return Warning
+	}
+
+	return Healthy
+}
+
+func (c healthcheckCriteria) evaluateJSON(body []byte) error {
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return fmt.Errorf("invalid json body: %w", err)
+	}
+
+	value, ok := lookupJSONPath(document, c.jsonPath)
+	if !ok || value == nil {
+		return errors.New("json path not found")
+	}
+
+	if c.jsonValue == "" {
+		return nil
+	}
+
+	actual, ok := value.(string)
+	if !ok {
+		bytes, _ := json.Marshal(value)
+		actual = string(bytes)
+	}
+
+	if actual != c.jsonValue {
+		return fmt.Errorf("expected json value %q but got %q", c.jsonValue, actual)
+	}
+	return nil
+}
+
+// parseStatusCodes parses a comma separated list of status codes and ranges, like "200-299,401".
+func parseStatusCodes(value string) ([]statusCodeRange, error) {
+	ranges := make([]statusCodeRange, 0)
+	if strings.TrimSpace(value) == "" {
+		return ranges, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		fromString, toString, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			toString = fromString
+		}
+
+		from, err := parseStatusCode(fromString)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parseStatusCode(toString)
+		if err != nil {
+			return nil, err
+		}
+
+		if from > to {
+			return nil, fmt.Errorf("invalid range %s", part)
+		}
+		ranges = append(ranges, statusCodeRange{from: from, to: to})
+	}
+	return ranges, nil
+}
+
+func parseStatusCode(value string) (int, error) {
+	statusCode, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || statusCode < 100 || statusCode > 599 {
+		return 0, fmt.Errorf("invalid status code %q", value)
+	}
+	return statusCode, nil
+}
+
+// parseJSONPath parses a path of object keys and array indexes, like "$.checks[0].status".
+// The segments are strings for keys and ints for indexes.
+func parseJSONPath(path string) ([]any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "$"), ".")
+
+	segments := make([]any, 0)
+	if path == "" {
+		return segments, nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		key, indexes, hasIndex := strings.Cut(part, "[")
+		if key == "" && !hasIndex {
+			return nil, fmt.Errorf("empty segment in %q", path)
+		}
+
+		if key != "" {
+			segments = append(segments, key)
+		}
+
+		for hasIndex {
+			var index, rest string
+			var ok bool
+			if index, rest, ok = strings.Cut(indexes, "]"); !ok {
+				return nil, fmt.Errorf("unclosed index in %q", path)
+			}
+
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid index %q in %q", index, path)
+			}
+			segments = append(segments, i)
+
+			if rest != "" && !strings.HasPrefix(rest, "[") {
+				return nil, fmt.Errorf("unexpected %q in %q", rest, path)
+			}
+			indexes, hasIndex = strings.CutPrefix(rest, "[")
+		}
+	}
+	return segments, nil
+}
+
+func lookupJSONPath(document any, path []any) (any, bool) {
+	value := document
+	for _, segment := range path {
+		switch segment := segment.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[segment]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || segment >= len(array) {
+				return nil, false
+			}
+			value = array[segment]
+		}
+	}
+	return value, true
+}
+
+func isHealthcheckMethod(method string) bool {
+	return slices.Contains(healthcheckMethods, method)
+}
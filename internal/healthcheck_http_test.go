@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseStatusCodes(t *testing.T) {
+	ranges, err := parseStatusCodes("200-299, 401")
+	assert.NoError(t, err)
+	assert.Equal(t, []statusCodeRange{{from: 200, to: 299}, {from: 401, to: 401}}, ranges)
+
+	ranges, err = parseStatusCodes("")
+	assert.NoError(t, err)
+	assert.Empty(t, ranges)
+
+	for _, invalid := range []string{"abc", "299-200", "200-", "99", "600", "200,,201"} {
+		_, err = parseStatusCodes(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func Test_parseJSONPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []any
+	}{
+		{path: "status", expected: []any{"status"}},
+		{path: "$.status.db", expected: []any{"status", "db"}},
+		{path: "$.checks[0].status", expected: []any{"checks", 0, "status"}},
+		{path: "$[1][2]", expected: []any{1, 2}},
+		{path: "$", expected: []any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			segments, err := parseJSONPath(tt.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, segments)
+		})
+	}
+
+	for _, invalid := range []string{"a..b", "a[0", "a[x]", "a[-1]", "a[0]b"} {
+		_, err := parseJSONPath(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHealthcheckCriteria_evaluate(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthcheck AppHealthcheck
+		statusCode  int
+		body        string
+		latency     time.Duration
+		expected    AppHealth
+	}{
+		{name: "default ok", statusCode: 200, expected: Healthy},
+		{name: "default redirect", statusCode: 302, expected: Healthy},
+		{name: "default unauthorized", statusCode: 401, expected: Warning},
+		{name: "default not found", statusCode: 404, expected: Error},
+		{name: "default server error", statusCode: 503, expected: Error},
+		{
+			name:        "accepted status code",
+			healthcheck: AppHealthcheck{StatusCodes: "200-299,401"},
+			statusCode:  401,
+			expected:    Healthy,
+		},
+		{
+			name:        "rejected status code",
+			healthcheck: AppHealthcheck{StatusCodes: "200"},
+			statusCode:  204,
+			expected:    Error,
+		},
+		{
+			name:        "body matches",
+			healthcheck: AppHealthcheck{BodyRegex: "(?i)ok"},
+			statusCode:  200,
+			body:        "Status: OK",
+			expected:    Healthy,
+		},
+		{
+			name:        "body does not match",
+			healthcheck: AppHealthcheck{BodyRegex: "ok"},
+			statusCode:  200,
+			body:        "failing",
+			expected:    Error,
+		},
+		{
+			name:        "json value matches",
+			healthcheck: AppHealthcheck{JSONPath: "$.checks[0].status", JSONValue: "up"},
+			statusCode:  200,
+			body:        `{"checks": [{"status": "up"}]}`,
+			expected:    Healthy,
+		},
+		{
+			name:        "json non string value matches",
+			healthcheck: AppHealthcheck{JSONPath: "healthy", JSONValue: "true"},
+			statusCode:  200,
+			body:        `{"healthy": true}`,
+			expected:    Healthy,
+		},
+		{
+			name:        "json value differs",
+			healthcheck: AppHealthcheck{JSONPath: "status", JSONValue: "up"},
+			statusCode:  200,
+			body:        `{"status": "down"}`,
+			expected:    Error,
+		},
+		{
+			name:        "json path missing",
+			healthcheck: AppHealthcheck{JSONPath: "status"},
+			statusCode:  200,
+			body:        `{}`,
+			expected:    Error,
+		},
+		{
+			name:        "invalid json",
+			healthcheck: AppHealthcheck{JSONPath: "status"},
+			statusCode:  200,
+			body:        `<html>`,
+			expected:    Error,
+		},
+		{
+			name:        "slow",
+			healthcheck: AppHealthcheck{MaxLatency: time.Second},
+			statusCode:  200,
+			latency:     2 * time.Second,
+			expected:    Warning,
+		},
+		{
+			name:        "slow and failing",
+			healthcheck: AppHealthcheck{MaxLatency: time.Second},
+			statusCode:  500,
+			latency:     2 * time.Second,
+			expected:    Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria, err := newHealthcheckCriteria(tt.healthcheck)
+			assert.NoError(t, err)
+
+			health, _ := criteria.evaluate(tt.statusCode, []byte(tt.body), tt.latency)
+			assert.Equal(t, tt.expected, health)
+		})
+	}
+}
+
+func Test_newHTTPChecker_headWithBodyCriteria(t *testing.T) {
+	healthcheck := AppHealthcheck{Enabled: true, Link: "http://app.local", Method: http.MethodHead, BodyRegex: "ok"}
+	errs := healthcheck.Validate()
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "body_regex and json_path can't be used with the HEAD method")
+	}
+
+	healthcheck = AppHealthcheck{Enabled: true, Link: "http://app.local", Method: http.MethodHead, JSONPath: "status"}
+	assert.NotEmpty(t, healthcheck.Validate())
+
+	healthcheck = AppHealthcheck{Enabled: true, Link: "http://app.local", Method: http.MethodHead, StatusCodes: "200"}
+	assert.Empty(t, healthcheck.Validate())
+}
+
+func TestHealthChecker_healthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case r.URL.Path == "/login":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			_, _ = w.Write([]byte(`{"status": "up"}`))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		healthcheck AppHealthcheck
+		expected    AppHealth
+	}{
+		{
+			name: "method and headers",
+			healthcheck: AppHealthcheck{
+				Link:      server.URL + "/health",
+				Method:    http.MethodPost,
+				Headers:   map[string]string{"Authorization": "Bearer token"},
+				JSONPath:  "status",
+				JSONValue: "up",
+			},
+			expected: Healthy,
+		},
+		{
+			name:        "missing headers",
+			healthcheck: AppHealthcheck{Link: server.URL + "/health", Method: http.MethodPost},
+			expected:    Warning,
+		},
+		{
+			name:        "follows redirects",
+			healthcheck: AppHealthcheck{Link: server.URL + "/redirect", FollowRedirects: true},
+			expected:    Warning,
+		},
+		{
+			name:        "does not follow redirects",
+			healthcheck: AppHealthcheck{Link: server.URL + "/redirect", StatusCodes: "302"},
+			expected:    Healthy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Empty(t, tt.healthcheck.Validate())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			checker := &healthChecker{ctx: ctx, url: tt.healthcheck.Link, logger: slog.Default()}
+			checker.setConfig(tt.healthcheck)
+			result, ok := checker.healthCheck()
+			assert.True(t, ok)
+			assert.Equal(t, tt.expected, result.Health)
+		})
+	}
+}
@@ -1,122 +1,324 @@
 package internal
 
 import (
-	"io"
+	"context"
+	"fmt"
 	"log/slog"
-	"net/http"
-	"os"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// HealthcheckService runs the health checks of the apps, by app id, as apps probing the same url
+// may check it differently.
 type HealthcheckService interface {
 	Init()
-	Get(url string) AppHealth
-	Urls() map[string]bool
-	Add(url string, interval time.Duration, timeout time.Duration)
-	Remove(url string)
+	Get(appId string) AppHealth
+	Summary(appId string) HealthSummary
+	AppIds() map[string]bool
+	Add(appId string, healthcheck AppHealthcheck)
+	Remove(appId string)
 	Updates() <-chan struct{}
+	// Check runs the check of an app right away, notifying about the result even if the health didn't change.
+	Check(appId string) error
+	// Subscribe returns a channel receiving every change of the health of an app.
+	Subscribe() <-chan HealthTransition
+	// Shutdown waits for the service to stop after its context is cancelled, saving the histories if enabled.
+	Shutdown(ctx context.Context) error
 }
 
-func NewHealthcheckService() HealthcheckService {
+// NewHealthcheckService creates a service whose health checkers run until ctx is cancelled.
+// The histories of the health checks are persisted to historyFile, unless it is empty.
+func NewHealthcheckService(ctx context.Context, historyFile string) HealthcheckService {
 	return &healthcheckServiceImpl{
-		checkersByUrl:    make(map[string]*healthChecker),
-		checkersUpdateCh: make(chan string, 1),
-		updateCh:         make(chan struct{}, 1),
+		ctx:               ctx,
+		historyFile:       historyFile,
+		checkersByAppId:   make(map[string]*healthChecker),
+		restoredHistories: make(map[string]healthHistorySnapshot),
+		checkersUpdateCh:  make(chan HealthTransition, 1),
+		updateCh:          make(chan struct{}, 1),
+		doneCh:            make(chan struct{}),
+		logger:            slog.With("name", "healthcheck-service"),
 	}
 }
 
 type healthcheckServiceImpl struct {
-	checkersByUrl    map[string]*healthChecker
-	checkersUpdateCh chan string
-	updateCh         chan struct{}
+	ctx             context.Context
+	checkersByAppId map[string]*healthChecker
+	// restoredHistories holds the loaded histories until a checker for their app is added
+	restoredHistories map[string]healthHistorySnapshot
+	checkersUpdateCh  chan HealthTransition
+	updateCh          chan struct{}
+	subscribers       []chan HealthTransition
+	doneCh            chan struct{}
+	logger            *slog.Logger
+	historyFile       string
+	mutex             sync.RWMutex
+	subscribersMutex  sync.Mutex
+}
+
+// HealthTransition is a change of the reported health of an app, with the error of the check causing it.
+type HealthTransition struct {
+	Time  time.Time `json:"time"`
+	AppID string    `json:"app_id"`
+	URL   string    `json:"url"`
+	Error string    `json:"error"`
+	From  AppHealth `json:"from"`
+	To    AppHealth `json:"to"`
 }
 
 func (svc *healthcheckServiceImpl) Init() {
+	if svc.historyFile != "" {
+		histories, err := loadHealthHistories(svc.historyFile)
+		if err != nil {
+			svc.logger.Error("loading health histories", "file", svc.historyFile, "error", err)
+		}
+
+		svc.mutex.Lock()
+		svc.restoredHistories = histories
+		svc.mutex.Unlock()
+	}
+
 	go svc.listen()
 }
 
-func (svc *healthcheckServiceImpl) Get(url string) AppHealth {
-	if checker, ok := svc.checkersByUrl[url]; ok {
-		return checker.health
+func (svc *healthcheckServiceImpl) Shutdown(ctx context.Context) error {
+	select {
+	case <-svc.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (svc *healthcheckServiceImpl) Get(appId string) AppHealth {
+	svc.mutex.RLock()
+	defer svc.mutex.RUnlock()
+
+	if checker, ok := svc.checkersByAppId[appId]; ok {
+		return checker.getHealth()
 	}
 	return Error
 }
 
-func (svc *healthcheckServiceImpl) Urls() map[string]bool {
-	urls := make(map[string]bool)
-	for url := range svc.checkersByUrl {
-		urls[url] = true
+func (svc *healthcheckServiceImpl) Summary(appId string) HealthSummary {
+	svc.mutex.RLock()
+	defer svc.mutex.RUnlock()
+
+	if checker, ok := svc.checkersByAppId[appId]; ok {
+		return checker.getSummary()
 	}
-	return urls
+	return HealthSummary{Latencies: make([]float64, 0)}
 }
 
-func (svc *healthcheckServiceImpl) Add(url string, interval time.Duration, timeout time.Duration) {
-	if checker, ok := svc.checkersByUrl[url]; ok {
-		checker.update(interval, timeout)
-		return
+func (svc *healthcheckServiceImpl) AppIds() map[string]bool {
+	svc.mutex.RLock()
+	defer svc.mutex.RUnlock()
+
+	appIds := make(map[string]bool)
+	for appId := range svc.checkersByAppId {
+		appIds[appId] = true
 	}
-	svc.checkersByUrl[url] = newHealthChecker(url, interval, timeout, svc.checkersUpdateCh)
+	return appIds
 }
 
-func (svc *healthcheckServiceImpl) Remove(url string) {
-	if checker, ok := svc.checkersByUrl[url]; ok {
-		checker.stopCh <- struct{}{}
-		delete(svc.checkersByUrl, url)
+// Add starts checking the health of an app, or updates the config of its existing checker.
+// A checker always checks the same url, so it is replaced if the url changed.
+func (svc *healthcheckServiceImpl) Add(appId string, healthcheck AppHealthcheck) {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+
+	if checker, ok := svc.checkersByAppId[appId]; ok {
+		if checker.url == healthcheck.Link {
+			checker.update(healthcheck)
+			return
+		}
+		checker.cancel()
+	}
+	history := newHealthHistory()
+	if snapshot, ok := svc.restoredHistories[appId]; ok {
+		history.restore(snapshot)
+		delete(svc.restoredHistories, appId)
+	}
+	svc.checkersByAppId[appId] = newHealthChecker(svc.ctx, appId, healthcheck, history, svc.checkersUpdateCh)
+}
+
+func (svc *healthcheckServiceImpl) Remove(appId string) {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+
+	checker, ok := svc.checkersByAppId[appId]
+	if !ok {
 		return
 	}
+	checker.cancel()
+	delete(svc.checkersByAppId, appId)
+
+	// the metrics are by url, which other apps may still check
+	for _, other := range svc.checkersByAppId {
+		if other.url == checker.url {
+			return
+		}
+	}
+	healthcheckDuration.DeletePartialMatch(prometheus.Labels{"target": checker.url})
+	healthchecksTotal.DeletePartialMatch(prometheus.Labels{"target": checker.url})
+}
+
+func (svc *healthcheckServiceImpl) Check(appId string) error {
+	svc.mutex.RLock()
+	checker, ok := svc.checkersByAppId[appId]
+	svc.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("no health check for app %s", appId)
+	}
+
+	go func() {
+		checker.recheck()
+		svc.notifyUpdate()
+	}()
+	return nil
 }
 
 func (svc *healthcheckServiceImpl) Updates() <-chan struct{} {
 	return svc.updateCh
 }
 
+// listen notifies about health changes right away, and about new results in the histories periodically,
+// so that clients aren't flooded with an update per check.
 func (svc *healthcheckServiceImpl) listen() {
+	defer close(svc.doneCh)
+
+	publishTicker := time.NewTicker(DefaultHealthHistoryPublishInterval)
+	defer publishTicker.Stop()
+
+	saveTicker := time.NewTicker(DefaultHealthHistorySaveInterval)
+	defer saveTicker.Stop()
+
 	for {
-		<-svc.checkersUpdateCh
-		go func() {
-			svc.updateCh <- struct{}{}
-		}()
+		select {
+		case <-svc.ctx.Done():
+			svc.saveHistories()
+			return
+		case <-saveTicker.C:
+			svc.saveHistories()
+			continue
+		case <-publishTicker.C:
+		case transition := <-svc.checkersUpdateCh:
+			svc.publishTransition(transition)
+		}
+
+		svc.notifyUpdate()
+	}
+}
+
+// notifyUpdate coalesces updates, the consumer reads the latest health of every app anyway.
+func (svc *healthcheckServiceImpl) notifyUpdate() {
+	select {
+	case svc.updateCh <- struct{}{}:
+	default:
 	}
 }
 
+func (svc *healthcheckServiceImpl) Subscribe() <-chan HealthTransition {
+	svc.subscribersMutex.Lock()
+	defer svc.subscribersMutex.Unlock()
+
+	subscriber := make(chan HealthTransition, DefaultHealthTransitionBufferSize)
+	svc.subscribers = append(svc.subscribers, subscriber)
+	return subscriber
+}
+
+// publishTransition never blocks on a slow subscriber, transitions which don't fit its buffer are dropped.
+func (svc *healthcheckServiceImpl) publishTransition(transition HealthTransition) {
+	svc.subscribersMutex.Lock()
+	defer svc.subscribersMutex.Unlock()
+
+	for _, subscriber := range svc.subscribers {
+		select {
+		case subscriber <- transition:
+		default:
+			svc.logger.Warn("dropping health transition, subscriber is too slow", "appId", transition.AppID)
+		}
+	}
+}
+
+func (svc *healthcheckServiceImpl) saveHistories() {
+	if svc.historyFile == "" {
+		return
+	}
+
+	svc.mutex.RLock()
+	histories := make(map[string]healthHistorySnapshot, len(svc.checkersByAppId)+len(svc.restoredHistories))
+	// keep the histories which weren't claimed yet, their checkers may be added later on
+	for appId, snapshot := range svc.restoredHistories {
+		histories[appId] = snapshot
+	}
+	for appId, checker := range svc.checkersByAppId {
+		histories[appId] = checker.getHistory()
+	}
+	svc.mutex.RUnlock()
+
+	err := saveHealthHistories(svc.historyFile, histories)
+	if err != nil {
+		svc.logger.Error("saving health histories", "file", svc.historyFile, "error", err)
+	}
+}
+
+// healthChecker runs the checker of a health check periodically, and keeps track of the resulting health.
 type healthChecker struct {
-	updateCh chan<- string
-	stopCh   chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	updateCh chan<- HealthTransition
 	ticker   *time.Ticker
-	url      string
-	interval time.Duration
-	timeout  time.Duration
-	health   AppHealth
 	logger   *slog.Logger
+	checker  Checker
+	history  *healthHistory
+	damper   healthDamper
+	// checkMutex is held while a check runs, so that slow checks don't overlap and feed the damper out of order
+	checkMutex sync.Mutex
+	// checkerErr is set when the config is invalid for its type, which makes every check fail
+	checkerErr error
+	config     AppHealthcheck
+	appId      string
+	url        string
+	health     AppHealth
+	mutex      sync.RWMutex
 }
 
 func newHealthChecker(
-	url string,
-	interval time.Duration,
-	timeout time.Duration,
-	checkerUpdateCh chan<- string,
+	ctx context.Context,
+	appId string,
+	config AppHealthcheck,
+	history *healthHistory,
+	checkerUpdateCh chan<- HealthTransition,
 ) *healthChecker {
+	ctx, cancel := context.WithCancel(ctx)
 	checker := &healthChecker{
-		url:      url,
-		interval: interval,
-		timeout:  timeout,
+		ctx:      ctx,
+		cancel:   cancel,
+		appId:    appId,
+		url:      config.Link,
 		health:   Unknown,
+		history:  history,
+		damper:   healthDamper{confirmed: Unknown},
 		updateCh: checkerUpdateCh,
-		stopCh:   make(chan struct{}, 1),
-		logger:   slog.With("module", "healthcheck", "url", url),
+		ticker:   time.NewTicker(config.Interval),
+		logger:   slog.With("module", "healthcheck", "appId", appId, "url", config.Link),
 	}
+	checker.setConfig(config)
+
 	go checker.updateHealth()
 	go checker.run()
 	return checker
 }
 
 func (h *healthChecker) run() {
-	h.ticker = time.NewTicker(h.interval)
 	defer h.ticker.Stop()
 
 	for {
 		select {
-		case <-h.stopCh:
+		case <-h.ctx.Done():
 			return
 		case <-h.ticker.C:
 			go h.updateHealth()
@@ -124,42 +326,173 @@ func (h *healthChecker) run() {
 	}
 }
 
-func (h *healthChecker) update(interval time.Duration, timeout time.Duration) {
-	h.ticker.Reset(interval)
-	h.timeout = timeout
+func (h *healthChecker) update(config AppHealthcheck) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.config.Interval != config.Interval {
+		h.ticker.Reset(config.Interval)
+	}
+	h.setConfig(config)
+}
+
+// setConfig must be called with the mutex held, or before the checker is started.
+func (h *healthChecker) setConfig(config AppHealthcheck) {
+	h.config = config
+	h.checker, h.checkerErr = newChecker(config)
+	if h.checkerErr != nil {
+		h.logger.Error("invalid healthcheck config", "error", h.checkerErr)
+	}
+}
+
+func (h *healthChecker) getHealth() AppHealth {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.health
+}
+
+func (h *healthChecker) getSummary() HealthSummary {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.history.summary(time.Now())
 }
 
+func (h *healthChecker) getHistory() healthHistorySnapshot {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.history.snapshot()
+}
+
+// updateHealth runs a periodic check, which is skipped while the previous one is still running.
 func (h *healthChecker) updateHealth() {
-	health := h.healthCheck()
-	if h.health != health {
-		h.health = health
-		h.updateCh <- h.url
+	if !h.checkMutex.TryLock() {
+		h.logger.Debug("skipping check, the previous one is still running")
+		return
 	}
+	defer h.checkMutex.Unlock()
+	h.check()
 }
 
-func (h *healthChecker) healthCheck() AppHealth {
-	client := http.Client{Timeout: h.timeout}
-	response, err := client.Get(h.url)
-	if err != nil {
-		h.logger.Error("http error", "error", err)
-		if os.IsTimeout(err) {
-			return Timeout
-		} else {
-			return Error
+// recheck runs a requested check after the running one, if any, so that its result is never older than the request.
+func (h *healthChecker) recheck() {
+	h.checkMutex.Lock()
+	defer h.checkMutex.Unlock()
+	h.check()
+}
+
+// check must be called with the checkMutex held.
+func (h *healthChecker) check() {
+	result, ok := h.healthCheck()
+	if !ok {
+		return
+	}
+
+	h.mutex.Lock()
+	health := h.damper.apply(result.Health, h.config.FailureThreshold, h.config.SuccessThreshold)
+	transition := HealthTransition{
+		Time:  result.Time,
+		AppID: h.appId,
+		URL:   h.url,
+		Error: result.Error,
+		From:  h.health,
+		To:    health,
+	}
+	h.health = health
+	h.history.record(result)
+	checkType := h.config.Type
+	h.mutex.Unlock()
+
+	healthcheckDuration.WithLabelValues(h.url, checkType).Observe(result.Latency.Seconds())
+	healthchecksTotal.WithLabelValues(h.url, checkType, result.Health.String()).Inc()
+
+	if transition.From != transition.To {
+		select {
+		case h.updateCh <- transition:
+		case <-h.ctx.Done():
 		}
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(response.Body)
+}
+
+// healthCheck runs the checker, retrying failed attempts as configured.
+// The result is not ok if the checker was stopped in the meantime.
+func (h *healthChecker) healthCheck() (HealthResult, bool) {
+	h.mutex.RLock()
+	config, checker, checkerErr := h.config, h.checker, h.checkerErr
+	h.mutex.RUnlock()
 
-	if response.StatusCode >= 400 {
-		h.logger.Warn("http status code", "statusCode", response.StatusCode)
-		if response.StatusCode == 404 || response.StatusCode >= 500 {
-			return Error
+	if checkerErr != nil {
+		return HealthResult{Time: time.Now(), Health: Error, Error: checkerErr.Error()}, true
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, ok := h.attempt(checker, config.Timeout)
+		if !ok || isUp(result.Health) || attempt >= config.Retries {
+			return result, ok
+		}
+
+		h.logger.Debug("retrying", "attempt", attempt+1, "retries", config.Retries)
+		select {
+		case <-h.ctx.Done():
+			return result, false
+		case <-time.After(DefaultHealthcheckRetryDelay):
 		}
-		return Warning
 	}
+}
+
+func (h *healthChecker) attempt(checker Checker, timeout time.Duration) (HealthResult, bool) {
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
 
-	h.logger.Debug("success")
-	return Healthy
+	result := HealthResult{Time: time.Now()}
+	health, err := checker.Check(ctx)
+	result.Latency = time.Since(result.Time)
+	result.Health = health
+	if err == nil {
+		h.logger.Debug("success")
+		return result, true
+	}
+
+	if h.ctx.Err() != nil {
+		return result, false
+	}
+
+	if isTimeout(err) {
+		result.Health = Timeout
+	}
+	result.Error = err.Error()
+	h.logger.Warn("unhealthy", "health", result.Health, "error", err)
+	return result, true
+}
+
+// healthDamper confirms transitions between up and down over consecutive checks, so that
+// a single failed or successful check doesn't flip the health of an app. It starts with an Unknown health.
+type healthDamper struct {
+	confirmed AppHealth
+	failures  int
+	successes int
+}
+
+// apply records the health of a check, and returns the health to report: the confirmed health,
+// Degraded while failures of an app which is up are confirmed, and Pending while any other transition is.
+func (d *healthDamper) apply(health AppHealth, failureThreshold int, successThreshold int) AppHealth {
+	up := isUp(health)
+	if up {
+		d.successes++
+		d.failures = 0
+	} else {
+		d.failures++
+		d.successes = 0
+	}
+
+	switch {
+	case d.confirmed != Unknown && up == isUp(d.confirmed):
+		d.confirmed = health
+	case up && d.successes >= successThreshold, !up && d.failures >= failureThreshold:
+		d.confirmed = health
+	case up || d.confirmed == Unknown:
+		return Pending
+	default:
+		return Degraded
+	}
+	return d.confirmed
 }
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthDamper_apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		checks   []AppHealth
+		expected []AppHealth
+	}{
+		{
+			name:     "confirms the first health",
+			checks:   []AppHealth{Healthy, Healthy, Healthy},
+			expected: []AppHealth{Pending, Pending, Healthy},
+		},
+		{
+			name:     "confirms the first failure",
+			checks:   []AppHealth{Error, Error, Timeout},
+			expected: []AppHealth{Pending, Pending, Timeout},
+		},
+		{
+			name:     "ignores a single failure",
+			checks:   []AppHealth{Healthy, Healthy, Healthy, Error, Healthy},
+			expected: []AppHealth{Pending, Pending, Healthy, Degraded, Healthy},
+		},
+		{
+			name:     "goes down after consecutive failures",
+			checks:   []AppHealth{Healthy, Healthy, Healthy, Error, Timeout, Error},
+			expected: []AppHealth{Pending, Pending, Healthy, Degraded, Degraded, Error},
+		},
+		{
+			name:     "goes up after consecutive successes",
+			checks:   []AppHealth{Error, Error, Error, Healthy, Warning, Error, Healthy, Healthy, Warning},
+			expected: []AppHealth{Pending, Pending, Error, Pending, Pending, Error, Pending, Pending, Warning},
+		},
+		{
+			name:     "changes right away within up",
+			checks:   []AppHealth{Healthy, Healthy, Healthy, Warning, Healthy},
+			expected: []AppHealth{Pending, Pending, Healthy, Warning, Healthy},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			damper := healthDamper{confirmed: Unknown}
+			reported := make([]AppHealth, 0)
+			for _, health := range tt.checks {
+				reported = append(reported, damper.apply(health, 3, 3))
+			}
+			assert.Equal(t, tt.expected, reported)
+		})
+	}
+
+	damper := healthDamper{confirmed: Unknown}
+	assert.Equal(t, Error, damper.apply(Error, 1, 1))
+	assert.Equal(t, Healthy, damper.apply(Healthy, 1, 1))
+}
+
+type fakeChecker struct {
+	results []error
+	calls   int
+}
+
+func (c *fakeChecker) Check(context.Context) (AppHealth, error) {
+	err := c.results[min(c.calls, len(c.results)-1)]
+	c.calls++
+	if err != nil {
+		return Error, err
+	}
+	return Healthy, nil
+}
+
+func TestHealthChecker_retries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	checker := &fakeChecker{results: []error{errors.New("connection reset"), nil}}
+	healthChecker := &healthChecker{
+		ctx:     ctx,
+		logger:  slog.Default(),
+		checker: checker,
+		config:  AppHealthcheck{Timeout: time.Second, Retries: 2},
+	}
+
+	result, ok := healthChecker.healthCheck()
+	assert.True(t, ok)
+	assert.Equal(t, Healthy, result.Health)
+	assert.Equal(t, 2, checker.calls)
+
+	checker = &fakeChecker{results: []error{errors.New("connection refused")}}
+	healthChecker.checker = checker
+	healthChecker.config.Retries = 0
+
+	result, ok = healthChecker.healthCheck()
+	assert.True(t, ok)
+	assert.Equal(t, Error, result.Health)
+	assert.Equal(t, "connection refused", result.Error)
+	assert.Equal(t, 1, checker.calls)
+}
+
+// blockingChecker succeeds once released, counting the checks running at the same time.
+type blockingChecker struct {
+	release chan struct{}
+	checks  atomic.Int64
+	running atomic.Int64
+	maxRuns atomic.Int64
+}
+
+func (c *blockingChecker) Check(context.Context) (AppHealth, error) {
+	c.checks.Add(1)
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+	if running > c.maxRuns.Load() {
+		c.maxRuns.Store(running)
+	}
+	<-c.release
+	return Healthy, nil
+}
+
+func TestHealthChecker_noOverlappingChecks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	checker := &blockingChecker{release: make(chan struct{})}
+	healthChecker := &healthChecker{
+		ctx:      ctx,
+		logger:   slog.Default(),
+		checker:  checker,
+		history:  newHealthHistory(),
+		damper:   healthDamper{confirmed: Unknown},
+		updateCh: make(chan HealthTransition, 10),
+		config:   AppHealthcheck{Timeout: time.Second, FailureThreshold: 1, SuccessThreshold: 1},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		healthChecker.updateHealth()
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return checker.running.Load() == 1 }, time.Second, time.Millisecond)
+
+	healthChecker.updateHealth()
+	rechecked := make(chan struct{})
+	go func() {
+		healthChecker.recheck()
+		close(rechecked)
+	}()
+	close(checker.release)
+	<-done
+	<-rechecked
+	assert.Equal(t, int64(1), checker.maxRuns.Load())
+	assert.Equal(t, int64(2), checker.checks.Load(), "a tick during a slow check is skipped, a recheck runs after it")
+
+	healthChecker.updateHealth()
+	assert.Equal(t, Healthy, healthChecker.getHealth())
+}
+
+func TestHealthcheckService_check(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewHealthcheckService(ctx, "")
+	svc.Init()
+
+	healthcheck := AppHealthcheck{Type: HealthcheckHTTP, Link: server.URL, Method: http.MethodGet, Interval: time.Hour, Timeout: time.Second}
+	svc.Add("app", healthcheck)
+	assert.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, svc.Check("app"))
+	assert.Eventually(t, func() bool { return requests.Load() == 2 }, time.Second, 10*time.Millisecond,
+		"checks right away, instead of waiting for the interval")
+
+	assert.EqualError(t, svc.Check("unknown"), "no health check for app unknown")
+}
+
+func TestHealthcheckService_sameUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewHealthcheckService(ctx, "")
+	svc.Init()
+
+	get := AppHealthcheck{Enabled: true, Link: server.URL, Method: http.MethodGet, Interval: time.Hour, Timeout: time.Second}
+	post := get
+	post.Method = http.MethodPost
+	assert.Empty(t, get.Validate())
+	assert.Empty(t, post.Validate())
+
+	svc.Add("get", get)
+	svc.Add("post", post)
+	assert.Eventually(t, func() bool {
+		return svc.Summary("get").LastChecked != nil && svc.Summary("post").LastChecked != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "", svc.Summary("get").LastError)
+	assert.Equal(t, "got status code 405", svc.Summary("post").LastError, "each app keeps its own check of the url")
+
+	svc.Remove("get")
+	assert.Equal(t, map[string]bool{"post": true}, svc.AppIds())
+}
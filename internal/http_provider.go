@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type HTTPProviderConfig struct {
+	Headers      map[string]string `json:"headers"       yaml:"headers"`
+	URL          string            `json:"url"           yaml:"url"`
+	Websocket    string            `json:"websocket"     yaml:"websocket"`
+	PrefixGroups bool              `json:"prefix_groups" yaml:"prefix_groups"`
+	Interval     time.Duration     `json:"interval"      yaml:"interval"`
+	Timeout      time.Duration     `json:"timeout"       yaml:"timeout"`
+}
+
+// HTTPProvider aggregates the apps of a remote source serving a json list of app groups,
+// usually another simplydash instance. Health is reported by the remote, and is not checked locally.
+type HTTPProvider struct {
+	*providerRuntime
+	logger           *slog.Logger
+	notificationChan chan<- string
+	client           *http.Client
+	id               string
+	name             string
+	etag             string
+	apps             []App
+	fetched          []App
+	// fetchFailed and websocketConnected track the two sources of apps, which are stale only when neither works.
+	fetchFailed        bool
+	websocketConnected bool
+	config             HTTPProviderConfig
+	mutex              sync.RWMutex
+}
+
+func NewHTTPProvider(ctx context.Context, name string, config HTTPProviderConfig, notificationChan chan<- string) Provider {
+	id := providerId("http", name)
+	return &HTTPProvider{
+		providerRuntime:  newProviderRuntime(ctx, id),
+		id:               id,
+		name:             name,
+		apps:             make([]App, 0),
+		fetched:          make([]App, 0),
+		config:           config,
+		client:           &http.Client{},
+		notificationChan: notificationChan,
+		logger:           slog.With("id", id),
+	}
+}
+
+func (hp *HTTPProvider) ID() string {
+	return hp.id
+}
+
+func (hp *HTTPProvider) Apps() []App {
+	hp.mutex.RLock()
+	defer hp.mutex.RUnlock()
+	return hp.apps
+}
+
+func (hp *HTTPProvider) Init() error {
+	if _, err := url.ParseRequestURI(hp.config.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if hp.config.Websocket != "" {
+		if _, err := url.ParseRequestURI(hp.config.Websocket); err != nil {
+			return fmt.Errorf("invalid websocket url: %w", err)
+		}
+	}
+
+	if hp.config.Interval <= 0 {
+		hp.config.Interval = DefaultHTTPProviderInterval
+	}
+	if hp.config.Timeout <= 0 {
+		hp.config.Timeout = DefaultHTTPProviderTimeout
+	}
+	hp.client.Timeout = hp.config.Timeout
+
+	hp.spawn(func() {
+		hp.fetch()
+		hp.poll()
+	})
+	if hp.config.Websocket != "" {
+		hp.spawn(hp.subscribe)
+	}
+	return nil
+}
+
+func (hp *HTTPProvider) poll() {
+	ticker := time.NewTicker(hp.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hp.ctx.Done():
+			return
+		case <-ticker.C:
+			hp.fetch()
+		case <-hp.resyncs():
+			hp.fetch()
+		}
+	}
+}
+
+func (hp *HTTPProvider) fetch() {
+	started := time.Now()
+	appGroups, modified, err := hp.request()
+
+	hp.mutex.Lock()
+	hp.fetchFailed = err != nil
+	hp.mutex.Unlock()
+
+	if err != nil {
+		hp.logger.Error("fetching apps", "url", hp.config.URL, "error", err)
+		hp.failed(err)
+		hp.publish(nil)
+		return
+	}
+	hp.synced(started)
+
+	if !modified {
+		hp.publish(nil)
+		return
+	}
+
+	hp.publish(hp.toApps(appGroups))
+}
+
+func (hp *HTTPProvider) request() (appGroups []AppGroup, modified bool, err error) {
+	ctx, cancel := context.WithTimeout(hp.ctx, hp.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, hp.config.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for key, value := range hp.config.Headers {
+		request.Header.Set(key, value)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	hp.mutex.RLock()
+	if hp.etag != "" {
+		request.Header.Set("If-None-Match", hp.etag)
+	}
+	hp.mutex.RUnlock()
+
+	response, err := hp.client.Do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	defer closeSafe(response.Body)
+
+	if response.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+
+	if response.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("got status code %d", response.StatusCode)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&appGroups)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hp.mutex.Lock()
+	hp.etag = response.Header.Get("ETag")
+	hp.mutex.Unlock()
+	return appGroups, true, nil
+}
+
+// subscribe follows the websocket feed of a remote simplydash instance, reconnecting with backoff.
+func (hp *HTTPProvider) subscribe() {
+	backoff := DefaultHTTPProviderMinBackoff
+	for {
+		err := hp.readWebsocket(func() { backoff = DefaultHTTPProviderMinBackoff })
+		if hp.ctx.Err() != nil {
+			return
+		}
+		hp.logger.Error("websocket feed", "url", hp.config.Websocket, "error", err, "retryIn", backoff)
+
+		select {
+		case <-hp.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, DefaultHTTPProviderMaxBackoff)
+	}
+}
+
+func (hp *HTTPProvider) readWebsocket(onConnected func()) error {
+	header := http.Header{}
+	for key, value := range hp.config.Headers {
+		header.Set(key, value)
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: hp.config.Timeout}
+	conn, _, err := dialer.DialContext(hp.ctx, hp.config.Websocket, header)
+	if err != nil {
+		return err
+	}
+	defer closeSafe(conn)
+	// unblock the read below when the provider is stopped
+	stopClosing := context.AfterFunc(hp.ctx, func() { _ = conn.Close() })
+	defer stopClosing()
+	onConnected()
+
+	hp.setWebsocketConnected(true)
+	defer hp.setWebsocketConnected(false)
+
+	var appGroups []AppGroup
+	var seq uint64
+	for {
+		var message WebsocketMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return err
+		}
+
+		switch message.Type {
+		case broadcastSnapshot:
+			var snapshot struct {
+				Apps []AppGroup `json:"apps"`
+			}
+			if err := json.Unmarshal(message.Data, &snapshot); err != nil {
+				return err
+			}
+			appGroups = snapshot.Apps
+		case broadcastApps:
+			// remote instances from before deltas send the full apps on every change
+			if err := json.Unmarshal(message.Data, &appGroups); err != nil {
+				return err
+			}
+		case deltaAppAdded, deltaAppRemoved, deltaAppUpdated, deltaHealthChanged:
+			if appGroups == nil || message.Seq != seq+1 {
+				// reconnecting gets a new snapshot
+				return fmt.Errorf("missed messages, got seq %d after %d", message.Seq, seq)
+			}
+
+			delta := AppDelta{Type: message.Type}
+			if err := json.Unmarshal(message.Data, &delta); err != nil {
+				return err
+			}
+			if !applyAppDelta(appGroups, delta) {
+				return fmt.Errorf("%s delta for unknown app %s", delta.Type, delta.ID)
+			}
+		default:
+			continue
+		}
+		seq = message.Seq
+
+		hp.publish(hp.toApps(appGroups))
+	}
+}
+
+// setWebsocketConnected republishes the apps when the websocket feed goes away,
+// as they become stale if polling doesn't work either.
+func (hp *HTTPProvider) setWebsocketConnected(connected bool) {
+	hp.mutex.Lock()
+	hp.websocketConnected = connected
+	hp.mutex.Unlock()
+
+	if !connected {
+		hp.publish(nil)
+	}
+}
+
+func (hp *HTTPProvider) toApps(appGroups []AppGroup) []App {
+	apps := make([]App, 0)
+	for _, appGroup := range appGroups {
+		for _, app := range appGroup.Apps {
+			if hp.config.PrefixGroups {
+				app.Group = fmt.Sprintf("%s / %s", hp.name, app.Group)
+			}
+			// the remote instance already checks the health of its apps
+			app.Healthcheck.Enabled = false
+
+			if errs := app.Validate(); len(errs) > 0 {
+				hp.logger.Error("invalid app from remote", "app", app.Name, "error", errors.Join(errs...))
+				continue
+			}
+			apps = insertOrdered(apps, app)
+		}
+	}
+	return apps
+}
+
+// publish updates the apps with the latest fetched ones, if any.
+// While the remote is unreachable, both by polling and over the websocket, its last known apps are kept,
+// but marked as stale.
+func (hp *HTTPProvider) publish(fetched []App) {
+	hp.mutex.Lock()
+	if fetched != nil {
+		hp.fetched = fetched
+	}
+
+	stale := hp.fetchFailed && !hp.websocketConnected
+
+	apps := make([]App, 0, len(hp.fetched))
+	for _, app := range hp.fetched {
+		app.Stale = stale
+		if stale {
+			app.Healthcheck.Health = Unknown
+		}
+		apps = append(apps, app)
+	}
+
+	changed := !reflect.DeepEqual(hp.apps, apps)
+	hp.apps = apps
+	hp.mutex.Unlock()
+
+	if changed {
+		notifyUpdate(hp.ctx, hp.notificationChan, hp.id)
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPProvider_fetch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`[{"name": "Media", "apps": [
+			{"name": "Plex", "link": "https://plex.example.com", "group": "Media", "healthcheck": {"enabled": true, "health": "healthy"}}
+		]}]`))
+	}))
+
+	notificationChan := make(chan string, 1)
+	provider := NewHTTPProvider(context.Background(), "remote", HTTPProviderConfig{
+		URL:          server.URL,
+		Headers:      map[string]string{"Authorization": "Bearer secret"},
+		PrefixGroups: true,
+	}, notificationChan).(*HTTPProvider)
+	provider.config.Timeout = DefaultHTTPProviderTimeout
+	provider.client.Timeout = DefaultHTTPProviderTimeout
+
+	provider.fetch()
+	<-notificationChan
+
+	apps := provider.Apps()
+	assert.Len(t, apps, 1)
+	assert.Equal(t, "remote / Media", apps[0].Group)
+	assert.Equal(t, Healthy, apps[0].Healthcheck.Health)
+	assert.False(t, apps[0].Healthcheck.Enabled)
+	assert.False(t, apps[0].Stale)
+
+	provider.fetch()
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, apps, provider.Apps())
+
+	server.Close()
+	provider.fetch()
+	<-notificationChan
+
+	apps = provider.Apps()
+	assert.Len(t, apps, 1)
+	assert.True(t, apps[0].Stale)
+	assert.Equal(t, Unknown, apps[0].Healthcheck.Health)
+
+	provider.setWebsocketConnected(true)
+	provider.fetch()
+	<-notificationChan
+	assert.False(t, provider.Apps()[0].Stale, "the websocket feed still works")
+
+	provider.setWebsocketConnected(false)
+	<-notificationChan
+	assert.True(t, provider.Apps()[0].Stale)
+}
+
+func TestHTTPProvider_readWebsocket(t *testing.T) {
+	app := deltaTestApp("one", "a", Healthy)
+	down := app
+	down.Healthcheck.Health = Error
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer closeSafe(conn)
+
+		apps, _ := json.Marshal(deltaTestGroups(app))
+		snapshot, _ := json.Marshal(BroadcastSnapshot{Settings: []byte("{}"), Apps: apps})
+		healthChanged, _ := json.Marshal(AppDelta{ID: app.ID, Group: app.Group, Healthcheck: &down.Healthcheck})
+		for _, message := range []WebsocketMessage{
+			{Type: broadcastSnapshot, Seq: 7, Data: snapshot},
+			{Type: deltaHealthChanged, Seq: 8, Data: healthChanged},
+			{Type: deltaHealthChanged, Seq: 10, Data: healthChanged},
+		} {
+			_ = conn.WriteJSON(message)
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	notificationChan := make(chan string, 10)
+	provider := NewHTTPProvider(context.Background(), "remote", HTTPProviderConfig{
+		Websocket: "ws" + strings.TrimPrefix(server.URL, "http"),
+		Timeout:   DefaultHTTPProviderTimeout,
+	}, notificationChan).(*HTTPProvider)
+
+	err := provider.readWebsocket(func() {})
+	assert.ErrorContains(t, err, "missed messages, got seq 10 after 8")
+
+	apps := provider.Apps()
+	assert.Len(t, apps, 1)
+	assert.Equal(t, Error, apps[0].Healthcheck.Health, "deltas are applied to the snapshot")
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,20 +12,25 @@ import (
 )
 
 type ImageService interface {
-	Get(urlString string) (string, error)
+	Get(ctx context.Context, urlString string) (string, error)
 }
 
-func NewImageService(cachePath string) ImageService {
+// NewImageService creates a service whose downloads are aborted once ctx is cancelled.
+func NewImageService(ctx context.Context, cachePath string) ImageService {
 	return &imageServiceImpl{
+		ctx:       ctx,
 		cachePath: cachePath,
 	}
 }
 
 type imageServiceImpl struct {
+	ctx       context.Context
 	cachePath string
 }
 
-func (svc *imageServiceImpl) Get(urlString string) (string, error) {
+// Get returns the path of the cached image, downloading it first if needed.
+// The download is aborted if either ctx or the context of the service is cancelled.
+func (svc *imageServiceImpl) Get(ctx context.Context, urlString string) (string, error) {
 	u, err := url.Parse(urlString)
 	if err != nil {
 		return "", err
@@ -32,27 +38,40 @@ func (svc *imageServiceImpl) Get(urlString string) (string, error) {
 
 	filePath := path.Join(svc.cachePath, u.Hostname(), u.Path)
 	_, err = os.Stat(filePath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return "", err
-		}
-
-		err = svc.downloadImage(u, filePath)
-		if err != nil {
-			return "", err
-		}
+	if err == nil {
+		imageCacheHitsTotal.Inc()
+		return filePath, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
 	}
+	imageCacheMissesTotal.Inc()
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(svc.ctx, cancel)()
+
+	err = svc.downloadImage(ctx, u, filePath)
+	if err != nil {
+		return "", err
+	}
 	return filePath, nil
 }
 
-func (svc *imageServiceImpl) downloadImage(u *url.URL, filePath string) error {
+// downloadImage writes to a temporary file which is renamed once complete,
+// so that aborted downloads never leave partial images in the cache.
+func (svc *imageServiceImpl) downloadImage(ctx context.Context, u *url.URL, filePath string) error {
 	err := os.MkdirAll(path.Dir(filePath), 0o755)
 	if err != nil {
 		return err
 	}
 
-	response, err := http.Get(u.String())
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -62,13 +81,23 @@ func (svc *imageServiceImpl) downloadImage(u *url.URL, filePath string) error {
 		return fmt.Errorf("got status code %d", response.StatusCode)
 	}
 
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(path.Dir(filePath), "."+path.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, response.Body)
+	imageCacheDownloadedBytesTotal.Add(float64(written))
+	closeSafe(file)
 	if err != nil {
+		_ = os.Remove(file.Name())
 		return err
 	}
-	defer closeSafe(file)
 
-	_, err = io.Copy(file, response.Body)
+	err = os.Rename(file.Name(), filePath)
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
 	return err
 }
 
@@ -0,0 +1,382 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	ingressRouteResource = schema.GroupVersionResource{
+		Group:    "traefik.io",
+		Version:  "v1alpha1",
+		Resource: "ingressroutes",
+	}
+
+	hostRuleRegex = regexp.MustCompile("Host\\(\\s*`([^`]+)`")
+)
+
+type KubernetesProviderConfig struct {
+	Kubeconfig    string        `json:"kubeconfig"     yaml:"kubeconfig"`
+	Context       string        `json:"context"        yaml:"context"`
+	Namespace     string        `json:"namespace"      yaml:"namespace"`
+	LabelSelector string        `json:"label_selector" yaml:"label_selector"`
+	IngressRoutes bool          `json:"ingress_routes" yaml:"ingress_routes"`
+	Resync        time.Duration `json:"resync"         yaml:"resync"`
+}
+
+type KubernetesClients struct {
+	Kubernetes kubernetes.Interface
+	Dynamic    dynamic.Interface
+}
+
+type KubernetesClientFunc func(config KubernetesProviderConfig) (KubernetesClients, error)
+
+// RealKubernetesClientFunc uses the configured kubeconfig if any, otherwise the in-cluster service account.
+func RealKubernetesClientFunc() KubernetesClientFunc {
+	return func(config KubernetesProviderConfig) (KubernetesClients, error) {
+		var restConfig *rest.Config
+		var err error
+		if config.Kubeconfig == "" {
+			restConfig, err = rest.InClusterConfig()
+		} else {
+			restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				&clientcmd.ClientConfigLoadingRules{ExplicitPath: config.Kubeconfig},
+				&clientcmd.ConfigOverrides{CurrentContext: config.Context},
+			).ClientConfig()
+		}
+		if err != nil {
+			return KubernetesClients{}, err
+		}
+
+		kubernetesClient, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return KubernetesClients{}, err
+		}
+
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return KubernetesClients{}, err
+		}
+
+		return KubernetesClients{Kubernetes: kubernetesClient, Dynamic: dynamicClient}, nil
+	}
+}
+
+type KubernetesProvider struct {
+	*providerRuntime
+	clientFunc       KubernetesClientFunc
+	logger           *slog.Logger
+	notificationChan chan<- string
+	refreshCh        chan struct{}
+	listers          []func() ([]App, error)
+	id               string
+	apps             []App
+	config           KubernetesProviderConfig
+	mutex            sync.RWMutex
+}
+
+func NewKubernetesProvider(ctx context.Context, name string, config KubernetesProviderConfig, notificationChan chan<- string) Provider {
+	id := providerId("kubernetes", name)
+	return &KubernetesProvider{
+		providerRuntime:  newProviderRuntime(ctx, id),
+		id:               id,
+		apps:             make([]App, 0),
+		config:           config,
+		clientFunc:       RealKubernetesClientFunc(),
+		notificationChan: notificationChan,
+		refreshCh:        make(chan struct{}, 1),
+		logger:           slog.With("id", id),
+	}
+}
+
+func (kp *KubernetesProvider) ID() string {
+	return kp.id
+}
+
+func (kp *KubernetesProvider) Apps() []App {
+	kp.mutex.RLock()
+	defer kp.mutex.RUnlock()
+	return kp.apps
+}
+
+func (kp *KubernetesProvider) Init() error {
+	if kp.config.Resync <= 0 {
+		kp.config.Resync = DefaultKubernetesResyncInterval
+	}
+
+	clients, err := kp.clientFunc(kp.config)
+	if err != nil {
+		return err
+	}
+
+	tweakListOptions := func(options *metav1.ListOptions) {
+		options.LabelSelector = kp.config.LabelSelector
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { kp.requestRefresh() },
+		UpdateFunc: func(any, any) { kp.requestRefresh() },
+		DeleteFunc: func(any) { kp.requestRefresh() },
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clients.Kubernetes,
+		kp.config.Resync,
+		informers.WithNamespace(kp.config.Namespace),
+		informers.WithTweakListOptions(tweakListOptions),
+	)
+
+	ingresses := factory.Networking().V1().Ingresses()
+	if _, err = ingresses.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+
+	services := factory.Core().V1().Services()
+	if _, err = services.Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+
+	kp.listers = []func() ([]App, error){
+		func() ([]App, error) {
+			list, err := ingresses.Lister().List(labels.Everything())
+			return mapEnabled(list, kp.ingressToApp), err
+		},
+		func() ([]App, error) {
+			list, err := services.Lister().List(labels.Everything())
+			return mapEnabled(list, kp.serviceToApp), err
+		},
+	}
+
+	synced := []cache.InformerSynced{ingresses.Informer().HasSynced, services.Informer().HasSynced}
+
+	if kp.config.IngressRoutes {
+		dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+			clients.Dynamic,
+			kp.config.Resync,
+			kp.config.Namespace,
+			tweakListOptions,
+		)
+
+		ingressRoutes := dynamicFactory.ForResource(ingressRouteResource)
+		if _, err = ingressRoutes.Informer().AddEventHandler(handler); err != nil {
+			return err
+		}
+
+		kp.listers = append(kp.listers, func() ([]App, error) {
+			list, err := ingressRoutes.Lister().List(labels.Everything())
+			objects := make([]*unstructured.Unstructured, 0, len(list))
+			for _, obj := range list {
+				if ingressRoute, ok := obj.(*unstructured.Unstructured); ok {
+					objects = append(objects, ingressRoute)
+				}
+			}
+			return mapEnabled(objects, kp.ingressRouteToApp), err
+		})
+		synced = append(synced, ingressRoutes.Informer().HasSynced)
+		dynamicFactory.Start(kp.ctx.Done())
+	}
+
+	factory.Start(kp.ctx.Done())
+
+	kp.spawn(func() { kp.run(synced) })
+	return nil
+}
+
+func (kp *KubernetesProvider) run(synced []cache.InformerSynced) {
+	if !cache.WaitForCacheSync(kp.ctx.Done(), synced...) {
+		if kp.ctx.Err() == nil {
+			kp.crash(errors.New("informer caches did not sync"))
+		}
+		return
+	}
+
+	kp.refresh()
+	for {
+		select {
+		case <-kp.ctx.Done():
+			return
+		case <-kp.refreshCh:
+			kp.refresh()
+		case <-kp.resyncs():
+			kp.refresh()
+		}
+	}
+}
+
+// requestRefresh coalesces bursts of informer events into a single refresh.
+func (kp *KubernetesProvider) requestRefresh() {
+	select {
+	case kp.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+func (kp *KubernetesProvider) refresh() {
+	started := time.Now()
+	apps := make([]App, 0)
+	for _, lister := range kp.listers {
+		listed, err := lister()
+		if err != nil {
+			kp.logger.Error("listing resources", "error", err)
+			kp.failed(err)
+			return
+		}
+
+		for _, app := range listed {
+			errs := app.Validate()
+			if len(errs) > 0 {
+				kp.logger.Error("invalid app specification", "app", app.Name, "error", errors.Join(errs...))
+			} else {
+				apps = insertOrdered(apps, app)
+			}
+		}
+	}
+
+	kp.mutex.Lock()
+	changed := !reflect.DeepEqual(kp.apps, apps)
+	kp.apps = apps
+	kp.mutex.Unlock()
+	kp.synced(started)
+
+	if changed {
+		notifyUpdate(kp.ctx, kp.notificationChan, kp.id)
+	}
+}
+
+func mapEnabled[T metav1.Object](objects []T, toApp func(T) App) []App {
+	apps := make([]App, 0)
+	for _, obj := range objects {
+		if boolFromLabels(obj.GetAnnotations(), simplydashEnable, false) {
+			apps = append(apps, toApp(obj))
+		}
+	}
+	return apps
+}
+
+func (kp *KubernetesProvider) ingressToApp(ingress *networkingv1.Ingress) App {
+	app := kubernetesObjectToApp(ingress)
+	if app.Link != "" {
+		return app
+	}
+
+	tlsHosts := make([]string, 0)
+	for _, tls := range ingress.Spec.TLS {
+		tlsHosts = append(tlsHosts, tls.Hosts...)
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" || isWildcardHost(rule.Host) {
+			continue
+		}
+
+		path := ""
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 && rule.HTTP.Paths[0].Path != "/" {
+			path = rule.HTTP.Paths[0].Path
+		}
+
+		app.Link = buildLink(slices.Contains(tlsHosts, rule.Host), rule.Host, path)
+		break
+	}
+	return app
+}
+
+func (kp *KubernetesProvider) serviceToApp(service *corev1.Service) App {
+	app := kubernetesObjectToApp(service)
+	if app.Link != "" || len(service.Status.LoadBalancer.Ingress) == 0 || len(service.Spec.Ports) == 0 {
+		return app
+	}
+
+	host := service.Status.LoadBalancer.Ingress[0].Hostname
+	if host == "" {
+		host = service.Status.LoadBalancer.Ingress[0].IP
+	}
+
+	port := service.Spec.Ports[0]
+	secure := port.Port == 443 || port.Name == "https"
+	if port.Port != 80 && port.Port != 443 {
+		host = fmt.Sprintf("%s:%d", host, port.Port)
+	}
+
+	app.Link = buildLink(secure, host, "")
+	return app
+}
+
+func (kp *KubernetesProvider) ingressRouteToApp(ingressRoute *unstructured.Unstructured) App {
+	app := kubernetesObjectToApp(ingressRoute)
+	if app.Link != "" {
+		return app
+	}
+
+	_, secure, _ := unstructured.NestedMap(ingressRoute.Object, "spec", "tls")
+	routes, _, _ := unstructured.NestedSlice(ingressRoute.Object, "spec", "routes")
+	for _, route := range routes {
+		routeMap, ok := route.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		match, _, _ := unstructured.NestedString(routeMap, "match")
+		if host := hostFromRule(match); host != "" && !isWildcardHost(host) {
+			app.Link = buildLink(secure, host, "")
+			break
+		}
+	}
+	return app
+}
+
+// kubernetesObjectToApp reads the simplydash annotations, defaulting the name to the object name
+// and the group to its namespace.
+func kubernetesObjectToApp(obj metav1.Object) App {
+	app := appFromLabels(obj.GetAnnotations())
+	if app.Name == "" {
+		app.Name = obj.GetName()
+	}
+	if app.Group == "" {
+		app.Group = obj.GetNamespace()
+	}
+	return app
+}
+
+// hostFromRule extracts the first host from a traefik rule, like "Host(`example.com`) && PathPrefix(`/`)".
+func hostFromRule(rule string) string {
+	matches := hostRuleRegex.FindStringSubmatch(rule)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+// isWildcardHost tells whether a host matches many names, like "*.example.com", which can't be linked to.
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*")
+}
+
+func buildLink(secure bool, host string, path string) string {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
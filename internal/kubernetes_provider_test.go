@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesProvider_apps(t *testing.T) {
+	enabled := map[string]string{simplydashEnable: "true"}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "grafana", Namespace: "monitoring", Annotations: enabled},
+		Spec: networkingv1.IngressSpec{
+			TLS:   []networkingv1.IngressTLS{{Hosts: []string{"grafana.example.com"}}},
+			Rules: []networkingv1.IngressRule{{Host: "grafana.example.com"}},
+		},
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "plex",
+			Namespace: "media",
+			Annotations: map[string]string{
+				simplydashEnable: "true",
+				simplydashGroup:  "Media",
+			},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 32400}}},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.10"}},
+		}},
+	}
+
+	ignored := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+	}
+
+	ingressRoute := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "traefik.io/v1alpha1",
+		"kind":       "IngressRoute",
+		"metadata": map[string]any{
+			"name":        "whoami",
+			"namespace":   "default",
+			"annotations": map[string]any{simplydashEnable: "true"},
+		},
+		"spec": map[string]any{
+			"routes": []any{map[string]any{"match": "Host(`whoami.example.com`) && PathPrefix(`/`)"}},
+		},
+	}}
+
+	notificationChan := make(chan string, 1)
+	provider := NewKubernetesProvider(context.Background(), "test", KubernetesProviderConfig{IngressRoutes: true}, notificationChan).(*KubernetesProvider)
+	provider.clientFunc = func(KubernetesProviderConfig) (KubernetesClients, error) {
+		return KubernetesClients{
+			Kubernetes: fake.NewSimpleClientset(ingress, service, ignored),
+			Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+				runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{ingressRouteResource: "IngressRouteList"},
+				ingressRoute,
+			),
+		}, nil
+	}
+
+	assert.NoError(t, provider.Init())
+	<-notificationChan
+
+	links := make(map[string]string)
+	groups := make(map[string]string)
+	for _, app := range provider.Apps() {
+		links[app.Name] = app.Link
+		groups[app.Name] = app.Group
+	}
+
+	assert.Equal(t, map[string]string{
+		"grafana": "https://grafana.example.com",
+		"plex":    "http://10.0.0.10:32400",
+		"whoami":  "http://whoami.example.com",
+	}, links)
+	assert.Equal(t, map[string]string{
+		"grafana": "monitoring",
+		"plex":    "Media",
+		"whoami":  "default",
+	}, groups)
+}
+
+func TestKubernetesProvider_ingressRouteToApp_wildcardHost(t *testing.T) {
+	provider := NewKubernetesProvider(context.Background(), "test", KubernetesProviderConfig{}, nil).(*KubernetesProvider)
+	ingressRoute := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{"name": "whoami", "namespace": "default"},
+		"spec": map[string]any{
+			"routes": []any{
+				map[string]any{"match": "Host(`*.example.com`)"},
+				map[string]any{"match": "Host(`whoami.example.com`)"},
+			},
+		},
+	}}
+	assert.Equal(t, "http://whoami.example.com", provider.ingressRouteToApp(ingressRoute).Link)
+
+	ingressRoute.Object["spec"] = map[string]any{"routes": []any{map[string]any{"match": "Host(`*.example.com`)"}}}
+	assert.Equal(t, "", provider.ingressRouteToApp(ingressRoute).Link)
+}
+
+func Test_hostFromRule(t *testing.T) {
+	assert.Equal(t, "example.com", hostFromRule("Host(`example.com`)"))
+	assert.Equal(t, "a.example.com", hostFromRule("PathPrefix(`/api`) && Host( `a.example.com`, `b.example.com`)"))
+	assert.Equal(t, "", hostFromRule("PathPrefix(`/`)"))
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	healthcheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "simplydash_healthcheck_duration_seconds",
+		Help:    "Latency of the health checks, by target.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"target", "type"})
+
+	healthchecksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "simplydash_healthchecks_total",
+		Help: "Health checks, by target and result.",
+	}, []string{"target", "type", "result"})
+
+	providerSyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "simplydash_provider_sync_duration_seconds",
+		Help:    "Duration of the successful syncs of the providers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	providerSyncErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "simplydash_provider_sync_errors_total",
+		Help: "Failed syncs of the providers.",
+	}, []string{"provider"})
+
+	websocketClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "simplydash_websocket_clients",
+		Help: "Connected websocket clients.",
+	})
+
+	imageCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "simplydash_image_cache_hits_total",
+		Help: "Images served from the cache.",
+	})
+
+	imageCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "simplydash_image_cache_misses_total",
+		Help: "Images which had to be downloaded.",
+	})
+
+	imageCacheDownloadedBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "simplydash_image_cache_downloaded_bytes_total",
+		Help: "Bytes of the images downloaded into the cache.",
+	})
+)
+
+// appHealthCollector reads the health of the apps when scraped, so that removed apps disappear right away.
+type appHealthCollector struct {
+	appService  AppService
+	description *prometheus.Desc
+}
+
+func newAppHealthCollector(appService AppService) *appHealthCollector {
+	return &appHealthCollector{
+		appService: appService,
+		description: prometheus.NewDesc(
+			"simplydash_app_health",
+			"Health of the apps: 1 if up, 0 if down, -1 if unknown or being confirmed.",
+			[]string{"id", "name", "group", "provider"},
+			nil,
+		),
+	}
+}
+
+func (c *appHealthCollector) Describe(descriptions chan<- *prometheus.Desc) {
+	descriptions <- c.description
+}
+
+// Collect labels the apps with their id, as names aren't unique, and skips apps with the same id as an earlier one,
+// since duplicate metrics would fail the whole scrape.
+func (c *appHealthCollector) Collect(metrics chan<- prometheus.Metric) {
+	collected := make(map[string]bool)
+	for _, group := range c.appService.GetApps() {
+		for _, app := range group.Apps {
+			if collected[app.ID] {
+				continue
+			}
+			collected[app.ID] = true
+
+			metrics <- prometheus.MustNewConstMetric(c.description, prometheus.GaugeValue,
+				healthValue(app.Healthcheck.Health), app.ID, app.Name, group.Name, app.Provider)
+		}
+	}
+}
+
+func healthValue(health AppHealth) float64 {
+	switch health {
+	case Healthy, Warning:
+		return 1
+	case Error, Timeout:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// metricsHandler serves the metrics from a registry of its own, leaving the default registry alone.
+func metricsHandler(appService AppService) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newAppHealthCollector(appService),
+		healthcheckDuration,
+		healthchecksTotal,
+		providerSyncDuration,
+		providerSyncErrorsTotal,
+		websocketClients,
+		imageCacheHitsTotal,
+		imageCacheMissesTotal,
+		imageCacheDownloadedBytesTotal,
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	appService := &fakeAppService{appGroups: []AppGroup{
+		{Name: "media", Apps: []App{
+			{ID: "a1", Name: "jellyfin", Provider: "docker-local", Healthcheck: AppHealthcheck{Health: Healthy}},
+			{ID: "a2", Name: "sonarr", Provider: "docker-local", Healthcheck: AppHealthcheck{Health: Timeout}},
+		}},
+		{Name: "infra", Apps: []App{
+			{ID: "a3", Name: "router", Provider: "file-apps", Healthcheck: AppHealthcheck{Health: Pending}},
+		}},
+	}}
+
+	started := time.Now()
+	runtime := newProviderRuntime(context.Background(), "file-metrics")
+	runtime.synced(started)
+	runtime.failed(io.ErrUnexpectedEOF)
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(appService).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	body := recorder.Body.String()
+	assert.Contains(t, body, `simplydash_app_health{group="media",id="a1",name="jellyfin",provider="docker-local"} 1`)
+	assert.Contains(t, body, `simplydash_app_health{group="media",id="a2",name="sonarr",provider="docker-local"} 0`)
+	assert.Contains(t, body, `simplydash_app_health{group="infra",id="a3",name="router",provider="file-apps"} -1`)
+	assert.Contains(t, body, `simplydash_provider_sync_duration_seconds_count{provider="file-metrics"} 1`)
+	assert.Contains(t, body, `simplydash_provider_sync_errors_total{provider="file-metrics"} 1`)
+	assert.Contains(t, body, "simplydash_websocket_clients 0")
+	assert.Contains(t, body, "simplydash_image_cache_hits_total")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestMetricsHandler_sameNamedApps(t *testing.T) {
+	appService := &fakeAppService{appGroups: []AppGroup{
+		{Name: "media", Apps: []App{
+			{ID: "a1", Name: "jellyfin", Provider: "docker-local", Healthcheck: AppHealthcheck{Health: Healthy}},
+			{ID: "a2", Name: "jellyfin", Provider: "docker-local", Healthcheck: AppHealthcheck{Health: Error}},
+			{ID: "a2", Name: "jellyfin", Provider: "docker-local", Healthcheck: AppHealthcheck{Health: Error}},
+		}},
+	}}
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(appService).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	body := recorder.Body.String()
+	assert.Contains(t, body, `simplydash_app_health{group="media",id="a1",name="jellyfin",provider="docker-local"} 1`)
+	assert.Contains(t, body, `simplydash_app_health{group="media",id="a2",name="jellyfin",provider="docker-local"} 0`)
+}
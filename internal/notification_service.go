@@ -0,0 +1,349 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+type NotificationsConfig struct {
+	Notifiers map[string]NotifierConfig `json:"notifiers" yaml:"notifiers"`
+	// Rules select the notifiers of each notification, all notifiers are used without any rules.
+	Rules []NotificationRule `json:"rules" yaml:"rules"`
+	// Cooldown is the minimum time between notifications of the same notifier, app and direction.
+	Cooldown time.Duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// NotificationRule sends the notifications matching all of its filters to its notifiers. Empty filters match everything.
+type NotificationRule struct {
+	// Notifiers are the names of the notifiers, all of them if empty.
+	Notifiers []string `json:"notifiers" yaml:"notifiers"`
+	Groups    []string `json:"groups"    yaml:"groups"`
+	// Apps are matched by name.
+	Apps []string `json:"apps" yaml:"apps"`
+	// Severities are matched against the new health of the app.
+	Severities []AppHealth `json:"severities" yaml:"severities"`
+}
+
+func (r NotificationRule) matches(notification Notification) bool {
+	return (len(r.Groups) == 0 || slices.Contains(r.Groups, notification.App.Group)) &&
+		(len(r.Apps) == 0 || slices.Contains(r.Apps, notification.App.Name)) &&
+		(len(r.Severities) == 0 || slices.Contains(r.Severities, notification.To))
+}
+
+type NotificationService interface {
+	Init()
+	Reload(config Config)
+	// Shutdown waits for the service to stop after its context is cancelled, and for the notifications being sent.
+	Shutdown(ctx context.Context) error
+}
+
+func NewNotificationService(
+	ctx context.Context,
+	config NotificationsConfig,
+	healthcheckService HealthcheckService,
+	appService AppService,
+) NotificationService {
+	svc := &notificationServiceImpl{
+		ctx:                ctx,
+		healthcheckService: healthcheckService,
+		appService:         appService,
+		confirmed:          make(map[string]AppHealth),
+		lastSent:           make(map[notificationKey]time.Time),
+		reloadCh:           make(chan NotificationsConfig),
+		doneCh:             make(chan struct{}),
+		logger:             slog.With("name", "notification-service"),
+	}
+	svc.reload(config)
+	return svc
+}
+
+// notificationServiceImpl is owned by the goroutine running listen, only it reads and writes its fields,
+// but for lastSent which is also written when notifications are delivered.
+type notificationServiceImpl struct {
+	ctx                context.Context
+	healthcheckService HealthcheckService
+	appService         AppService
+	notifiers          map[string]Notifier
+	// confirmed is the latest health of every app which was either up or down
+	confirmed map[string]AppHealth
+	// lastSent is the time of the latest delivered notification of every key, for the cooldown
+	lastSent      map[notificationKey]time.Time
+	reloadCh      chan NotificationsConfig
+	doneCh        chan struct{}
+	logger        *slog.Logger
+	sending       sync.WaitGroup
+	config        NotificationsConfig
+	lastSentMutex sync.Mutex
+}
+
+// notificationKey identifies the notifications a cooldown applies to.
+type notificationKey struct {
+	notifier string
+	group    string
+	app      string
+	up       bool
+}
+
+func (svc *notificationServiceImpl) Init() {
+	go svc.listen(svc.healthcheckService.Subscribe())
+}
+
+func (svc *notificationServiceImpl) Reload(config Config) {
+	select {
+	case svc.reloadCh <- config.Notifications:
+	case <-svc.ctx.Done():
+	}
+}
+
+func (svc *notificationServiceImpl) Shutdown(ctx context.Context) error {
+	select {
+	case <-svc.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	sentCh := make(chan struct{})
+	go func() {
+		svc.sending.Wait()
+		close(sentCh)
+	}()
+
+	select {
+	case <-sentCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (svc *notificationServiceImpl) listen(transitions <-chan HealthTransition) {
+	defer close(svc.doneCh)
+
+	for {
+		select {
+		case <-svc.ctx.Done():
+			svc.closeNotifiers(svc.notifiers)
+			return
+		case config := <-svc.reloadCh:
+			svc.reload(config)
+		case transition := <-transitions:
+			svc.handle(transition)
+		}
+	}
+}
+
+// reload keeps the notifiers whose config didn't change, so that they don't lose the notifications they hold back.
+func (svc *notificationServiceImpl) reload(config NotificationsConfig) {
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultNotificationCooldown
+	}
+	if svc.notifiers != nil && reflect.DeepEqual(config, svc.config) {
+		return
+	}
+
+	notifiers := make(map[string]Notifier, len(config.Notifiers))
+	for name, notifierConfig := range config.Notifiers {
+		if existing, ok := svc.notifiers[name]; ok && reflect.DeepEqual(notifierConfig, svc.config.Notifiers[name]) {
+			notifiers[name] = existing
+			continue
+		}
+
+		notifier, err := newNotifier(notifierConfig)
+		if err != nil {
+			svc.logger.Error("invalid notifier, skipping", "notifier", name, "error", err)
+			continue
+		}
+		if holding, ok := notifier.(holdingNotifier); ok {
+			holding.onDelivered(func(notification Notification) {
+				svc.recordSent(svc.notificationKey(name, notification), notification.Time)
+			})
+		}
+		notifiers[name] = notifier
+	}
+
+	removed := make(map[string]Notifier)
+	for name, notifier := range svc.notifiers {
+		if notifiers[name] != notifier {
+			removed[name] = notifier
+		}
+	}
+	svc.closeNotifiers(removed)
+	svc.config = config
+	svc.notifiers = notifiers
+}
+
+// closeNotifiers closes the notifiers holding notifications back, like email digests, letting them send what they hold.
+func (svc *notificationServiceImpl) closeNotifiers(notifiers map[string]Notifier) {
+	for name, notifier := range notifiers {
+		closer, ok := notifier.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		svc.sending.Add(1)
+		go func() {
+			defer svc.sending.Done()
+			if err := closer.Close(); err != nil {
+				svc.logger.Error("failed to close notifier", "notifier", name, "error", err)
+			}
+		}()
+	}
+}
+
+// handle notifies when an app goes down or comes back up. Unconfirmed states are ignored, and so is the first state
+// of an app unless it is down, so that starting up doesn't notify about every healthy app.
+func (svc *notificationServiceImpl) handle(transition HealthTransition) {
+	if transition.To != Healthy && transition.To != Warning && transition.To != Timeout && transition.To != Error {
+		return
+	}
+
+	appGroups := svc.appService.GetApps()
+	svc.pruneConfirmed(appGroups, transition.AppID)
+
+	from, known := svc.confirmed[transition.AppID]
+	svc.confirmed[transition.AppID] = transition.To
+	if known && isUp(from) == isUp(transition.To) {
+		return
+	}
+	if !known {
+		if isUp(transition.To) {
+			return
+		}
+		from = Unknown
+	}
+
+	for _, group := range appGroups {
+		for _, app := range group.Apps {
+			if app.ID != transition.AppID {
+				continue
+			}
+			svc.notify(Notification{Time: transition.Time, App: app, Error: transition.Error, From: from, To: transition.To})
+		}
+	}
+}
+
+// pruneConfirmed forgets the apps which are gone, except for the app of the transition being handled,
+// which may not be in the apps yet.
+func (svc *notificationServiceImpl) pruneConfirmed(appGroups []AppGroup, appId string) {
+	appIds := make(map[string]bool)
+	for _, group := range appGroups {
+		for _, app := range group.Apps {
+			appIds[app.ID] = true
+		}
+	}
+
+	for id := range svc.confirmed {
+		if id != appId && !appIds[id] {
+			delete(svc.confirmed, id)
+		}
+	}
+}
+
+func (svc *notificationServiceImpl) notify(notification Notification) {
+	for _, name := range svc.matchingNotifiers(notification) {
+		notifier, ok := svc.notifiers[name]
+		if !ok {
+			continue
+		}
+
+		key := svc.notificationKey(name, notification)
+		undo, ok := svc.startCooldown(key, notification.Time)
+		if !ok {
+			svc.logger.Debug("skipping notification in cooldown", "notifier", name, "app", notification.App.Name)
+			continue
+		}
+
+		svc.sending.Add(1)
+		go func() {
+			defer svc.sending.Done()
+
+			// notifications already being sent are allowed to finish on shutdown
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(svc.ctx), DefaultNotificationTimeout)
+			defer cancel()
+
+			err := notifier.Notify(ctx, notification)
+			if err != nil {
+				// only delivered notifications start the cooldown, held back ones do once they are delivered
+				undo()
+			}
+			if err != nil && !errors.Is(err, errNotificationHeld) {
+				svc.logger.Error("failed to send notification", "notifier", name, "app", notification.App.Name, "error", err)
+			}
+		}()
+	}
+}
+
+func (svc *notificationServiceImpl) notificationKey(notifier string, notification Notification) notificationKey {
+	return notificationKey{notifier: notifier, group: notification.App.Group, app: notification.App.Name, up: isUp(notification.To)}
+}
+
+// startCooldown records the notification as sent while it is being sent, so that it isn't sent twice, unless the key
+// is in cooldown. undo restores the previous time if the notification isn't delivered after all.
+func (svc *notificationServiceImpl) startCooldown(key notificationKey, at time.Time) (undo func(), ok bool) {
+	svc.lastSentMutex.Lock()
+	defer svc.lastSentMutex.Unlock()
+
+	previous, hasPrevious := svc.lastSent[key]
+	if hasPrevious && at.Sub(previous) < svc.config.Cooldown {
+		return nil, false
+	}
+	svc.lastSent[key] = at
+
+	return func() {
+		svc.lastSentMutex.Lock()
+		defer svc.lastSentMutex.Unlock()
+
+		if !svc.lastSent[key].Equal(at) {
+			return
+		}
+		if hasPrevious {
+			svc.lastSent[key] = previous
+		} else {
+			delete(svc.lastSent, key)
+		}
+	}, true
+}
+
+func (svc *notificationServiceImpl) recordSent(key notificationKey, at time.Time) {
+	svc.lastSentMutex.Lock()
+	defer svc.lastSentMutex.Unlock()
+
+	if at.After(svc.lastSent[key]) {
+		svc.lastSent[key] = at
+	}
+}
+
+func (svc *notificationServiceImpl) matchingNotifiers(notification Notification) []string {
+	all := make([]string, 0, len(svc.notifiers))
+	for name := range svc.notifiers {
+		all = append(all, name)
+	}
+	if len(svc.config.Rules) == 0 {
+		return all
+	}
+
+	names := make([]string, 0, len(all))
+	for _, rule := range svc.config.Rules {
+		if !rule.matches(notification) {
+			continue
+		}
+
+		ruleNotifiers := rule.Notifiers
+		if len(ruleNotifiers) == 0 {
+			ruleNotifiers = all
+		}
+
+		for _, name := range ruleNotifiers {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeAppService only implements reading apps and settings, the other methods panic.
+type fakeAppService struct {
+	AppService
+	updateCh  chan struct{}
+	appGroups []AppGroup
+	settings  AppConfig
+	mutex     sync.Mutex
+}
+
+func (f *fakeAppService) GetApps() []AppGroup {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.appGroups
+}
+
+func (f *fakeAppService) GetSettings() AppConfig {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.settings
+}
+
+func (f *fakeAppService) UpdateCh() <-chan struct{} {
+	return f.updateCh
+}
+
+// update replaces the apps, and the settings if not empty, and notifies about it.
+func (f *fakeAppService) update(appGroups []AppGroup, settings AppConfig) {
+	f.mutex.Lock()
+	f.appGroups = appGroups
+	if settings.Name != "" {
+		f.settings = settings
+	}
+	f.mutex.Unlock()
+	f.updateCh <- struct{}{}
+}
+
+// fakeHealthcheckService only implements Subscribe, the other methods panic.
+type fakeHealthcheckService struct {
+	HealthcheckService
+	transitions chan HealthTransition
+}
+
+func (f *fakeHealthcheckService) Subscribe() <-chan HealthTransition {
+	return f.transitions
+}
+
+func newTestNotificationService(t *testing.T, config NotificationsConfig, apps ...App) *notificationServiceImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	groups := make([]AppGroup, 0)
+	for _, app := range apps {
+		groups = append(groups, AppGroup{Name: app.Group, Apps: []App{app}})
+	}
+	return NewNotificationService(ctx, config, nil, &fakeAppService{appGroups: groups}).(*notificationServiceImpl)
+}
+
+func transition(appId string, at time.Time, to AppHealth) HealthTransition {
+	return HealthTransition{Time: at, AppID: appId, To: to}
+}
+
+func receivedCount(requests <-chan receivedRequest) int {
+	count := 0
+	for {
+		select {
+		case <-requests:
+			count++
+		case <-time.After(50 * time.Millisecond):
+			return count
+		}
+	}
+}
+
+func TestNotificationService_transitions(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusOK)
+	app := App{ID: "app", Name: "app", Group: "group"}
+	svc := newTestNotificationService(t, NotificationsConfig{
+		Notifiers: map[string]NotifierConfig{"slack": {Type: NotifierSlack, URL: server.URL}},
+	}, app)
+
+	now := time.Now()
+	svc.handle(transition("app", now, Pending))
+	svc.handle(transition("app", now, Healthy))
+	svc.handle(transition("app", now, Warning))
+	svc.handle(transition("app", now, Degraded))
+	assert.Equal(t, 0, receivedCount(requests), "starting up healthy, and staying up, doesn't notify")
+
+	svc.handle(transition("app", now, Error))
+	assert.Equal(t, `{"text":"app (group) is error, was warning"}`, (<-requests).body)
+	svc.handle(transition("app", now, Timeout))
+	svc.handle(transition("app", now, Healthy))
+	assert.Equal(t, `{"text":"app (group) is healthy, was timeout"}`, (<-requests).body)
+
+	svc.handle(transition("other", now, Error))
+	assert.Equal(t, 0, receivedCount(requests), "unknown apps don't notify")
+
+	svc.appService.(*fakeAppService).appGroups = []AppGroup{{Name: "group", Apps: []App{{ID: "other"}}}}
+	svc.handle(transition("other", now, Error))
+	assert.Equal(t, map[string]AppHealth{"other": Error}, svc.confirmed, "removed apps are forgotten")
+	assert.Equal(t, 0, receivedCount(requests))
+
+	svc = newTestNotificationService(t, svc.config, app)
+	svc.handle(transition("app", now, Error))
+	assert.Equal(t, `{"text":"app (group) is error, was unknown"}`, (<-requests).body, "starting up down notifies")
+}
+
+func TestNotificationService_cooldown(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusOK)
+	svc := newTestNotificationService(t, NotificationsConfig{
+		Notifiers: map[string]NotifierConfig{"slack": {Type: NotifierSlack, URL: server.URL}},
+		Cooldown:  time.Hour,
+	}, App{ID: "app", Name: "app"})
+
+	now := time.Now()
+	for i := range 3 {
+		svc.handle(transition("app", now.Add(time.Duration(i)*time.Minute), Error))
+		svc.handle(transition("app", now.Add(time.Duration(i)*time.Minute), Healthy))
+	}
+	assert.Equal(t, 2, receivedCount(requests), "only the first down and up within the cooldown")
+
+	svc.handle(transition("app", now.Add(time.Hour), Error))
+	assert.Equal(t, 1, receivedCount(requests))
+}
+
+func TestNotificationService_cooldownAfterFlap(t *testing.T) {
+	server := newSMTPStandIn(t, nil, false)
+	svc := newTestNotificationService(t, NotificationsConfig{
+		Notifiers: map[string]NotifierConfig{"email": {Type: NotifierEmail, SMTP: SMTPConfig{
+			Host:      "127.0.0.1",
+			Port:      server.port(),
+			TLS:       SMTPNoTLS,
+			From:      "dash@home.arpa",
+			To:        []string{"ops@home.arpa"},
+			DownDelay: 100 * time.Millisecond,
+		}}},
+		Cooldown: time.Hour,
+	}, App{ID: "app", Name: "app"})
+
+	now := time.Now()
+	svc.handle(transition("app", now, Error))
+	// notifications are sent concurrently, the down one must reach the notifier first
+	time.Sleep(20 * time.Millisecond)
+	svc.handle(transition("app", now.Add(time.Second), Healthy))
+	assert.Empty(t, receivedEmails(server, 200*time.Millisecond), "the flap is within the down delay")
+
+	svc.handle(transition("app", now.Add(time.Minute), Error))
+	emails := receivedEmails(server, 200*time.Millisecond)
+	if assert.Len(t, emails, 1, "the flap didn't start the cooldown") {
+		assert.Equal(t, "app is down", emails[0].subject)
+	}
+
+	svc.handle(transition("app", now.Add(2*time.Minute), Healthy))
+	emails = receivedEmails(server, 200*time.Millisecond)
+	if assert.Len(t, emails, 1) {
+		assert.Equal(t, "app is up", emails[0].subject)
+	}
+	svc.handle(transition("app", now.Add(3*time.Minute), Error))
+	assert.Empty(t, receivedEmails(server, 200*time.Millisecond), "the delivered down notification started the cooldown")
+}
+
+func TestNotificationService_reload(t *testing.T) {
+	server, _ := newReceiver(t, http.StatusOK)
+	config := NotificationsConfig{Notifiers: map[string]NotifierConfig{
+		"email": {Type: NotifierEmail, SMTP: SMTPConfig{Host: "127.0.0.1", From: "dash@home.arpa", To: []string{"ops@home.arpa"}}},
+		"slack": {Type: NotifierSlack, URL: server.URL},
+	}}
+	svc := newTestNotificationService(t, config)
+	email, slack := svc.notifiers["email"], svc.notifiers["slack"]
+
+	svc.reload(config)
+	assert.Same(t, email, svc.notifiers["email"])
+	assert.Same(t, slack, svc.notifiers["slack"])
+
+	config.Notifiers = map[string]NotifierConfig{
+		"email": config.Notifiers["email"],
+		"slack": {Type: NotifierSlack, URL: server.URL + "/other"},
+	}
+	svc.reload(config)
+	assert.Same(t, email, svc.notifiers["email"], "unchanged notifiers are kept")
+	assert.NotSame(t, slack, svc.notifiers["slack"])
+	assert.False(t, email.(*emailNotifier).closed)
+}
+
+func TestNotificationService_rules(t *testing.T) {
+	critical, criticalRequests := newReceiver(t, http.StatusOK)
+	all, allRequests := newReceiver(t, http.StatusOK)
+
+	var config NotificationsConfig
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+notifiers:
+  critical: {type: discord, url: `+critical.URL+`}
+  all: {type: slack, url: `+all.URL+`}
+  invalid: {type: pager, url: `+all.URL+`}
+rules:
+  - notifiers: [critical]
+    groups: [infra]
+    severities: [error, timeout]
+  - notifiers: [critical]
+    apps: [payments]
+  - notifiers: [all]
+`), &config))
+
+	svc := newTestNotificationService(t, config,
+		App{ID: "dns", Name: "dns", Group: "infra"},
+		App{ID: "payments", Name: "payments", Group: "shop"},
+		App{ID: "blog", Name: "blog", Group: "media"},
+	)
+	assert.Len(t, svc.notifiers, 2)
+
+	now := time.Now()
+	svc.handle(transition("dns", now, Error))
+	svc.handle(transition("dns", now, Healthy))
+	svc.handle(transition("payments", now, Timeout))
+	svc.handle(transition("blog", now, Error))
+	assert.Equal(t, 2, receivedCount(criticalRequests), "dns going down and payments")
+	assert.Equal(t, 4, receivedCount(allRequests))
+}
+
+func TestNotificationService_subscribe(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusOK)
+	healthcheckService := &fakeHealthcheckService{transitions: make(chan HealthTransition)}
+	appService := &fakeAppService{appGroups: []AppGroup{
+		{Name: "group", Apps: []App{{ID: "app", Name: "app", Group: "group"}}},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := NewNotificationService(ctx, NotificationsConfig{}, healthcheckService, appService)
+	svc.Init()
+
+	svc.Reload(Config{Notifications: NotificationsConfig{
+		Notifiers: map[string]NotifierConfig{"slack": {Type: NotifierSlack, URL: server.URL}},
+	}})
+	healthcheckService.transitions <- transition("app", time.Now(), Error)
+	assert.Equal(t, `{"text":"app (group) is error, was unknown"}`, (<-requests).body)
+
+	cancel()
+	assert.NoError(t, svc.Shutdown(context.Background()))
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	NotifierWebhook = "webhook"
+	NotifierNtfy    = "ntfy"
+	NotifierGotify  = "gotify"
+	NotifierDiscord = "discord"
+	NotifierSlack   = "slack"
+	NotifierMatrix  = "matrix"
+	NotifierEmail   = "email"
+)
+
+// defaultWebhookBody is the body template of webhooks without one.
+const defaultWebhookBody = `{"app": {{json .App.Name}}, "group": {{json .App.Group}}, "link": {{json .App.Link}}, ` +
+	`"from": {{json .From}}, "to": {{json .To}}, "time": {{json .Time}}, "error": {{json .Error}}, ` +
+	`"message": {{json .Message}}}`
+
+type NotifierConfig struct {
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	// Type is one of webhook, ntfy, gotify, discord, slack, matrix or email.
+	Type string `json:"type"    yaml:"type"`
+	URL  string `json:"url"     yaml:"url"`
+	// Body is the text/template of the body of webhooks, executed with the Notification.
+	Body string `json:"body"    yaml:"body"`
+	// Token is the access token for ntfy, the application token for gotify, and the access token for matrix.
+	Token string `json:"token"   yaml:"token"`
+	// Room is the id of the matrix room to send to.
+	Room string `json:"room"    yaml:"room"`
+	// SMTP configures email notifiers, which don't have an url.
+	SMTP SMTPConfig `json:"smtp" yaml:"smtp"`
+}
+
+// Notification tells about an app which went down or came back up.
+type Notification struct {
+	Time  time.Time
+	App   App
+	Error string
+	From  AppHealth
+	To    AppHealth
+}
+
+func (n Notification) Title() string {
+	if isUp(n.To) {
+		return fmt.Sprintf("%s is up", n.App.Name)
+	}
+	return fmt.Sprintf("%s is down", n.App.Name)
+}
+
+func (n Notification) Message() string {
+	message := fmt.Sprintf("%s (%s) is %s, was %s", n.App.Name, n.App.Group, n.To, n.From)
+	if n.Error != "" && !isUp(n.To) {
+		message += ": " + n.Error
+	}
+	return message
+}
+
+// errNotificationHeld is returned by notifiers which didn't deliver a notification yet, and may never do.
+var errNotificationHeld = errors.New("notification held back")
+
+// Notifier delivers notifications to a single endpoint.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// holdingNotifier is implemented by notifiers holding notifications back, like the down delay and digest of emails.
+// The held notifications are reported to the function given to onDelivered when they are delivered later.
+type holdingNotifier interface {
+	Notifier
+	onDelivered(delivered func(Notification))
+}
+
+// NotifierFactory builds the notifier of a config, failing if the config is invalid for the type.
+type NotifierFactory func(config NotifierConfig) (Notifier, error)
+
+var notifierFactories = map[string]NotifierFactory{
+	NotifierWebhook: withURL(newWebhookNotifier),
+	NotifierNtfy:    withURL(newNtfyNotifier),
+	NotifierGotify:  withURL(newGotifyNotifier),
+	NotifierDiscord: withURL(newChatNotifier("content")),
+	NotifierSlack:   withURL(newChatNotifier("text")),
+	NotifierMatrix:  withURL(newMatrixNotifier),
+	NotifierEmail:   newEmailNotifier,
+}
+
+// RegisterNotifier adds a notifier type, or replaces an existing one.
+// It is not safe for concurrent use, and must be called before the notification service is created.
+func RegisterNotifier(notifierType string, factory NotifierFactory) {
+	notifierFactories[notifierType] = factory
+}
+
+func newNotifier(config NotifierConfig) (Notifier, error) {
+	factory, ok := notifierFactories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported notifier type %s", config.Type)
+	}
+	return factory(config)
+}
+
+// withURL validates the url of the config before building notifiers which send to it.
+func withURL(factory NotifierFactory) NotifierFactory {
+	return func(config NotifierConfig) (Notifier, error) {
+		if _, err := url.ParseRequestURI(config.URL); err != nil {
+			return nil, fmt.Errorf("invalid notifier url: %w", err)
+		}
+		return factory(config)
+	}
+}
+
+// webhookNotifier posts the templated body to any url.
+type webhookNotifier struct {
+	body   *template.Template
+	config NotifierConfig
+}
+
+func newWebhookNotifier(config NotifierConfig) (Notifier, error) {
+	body := config.Body
+	if strings.TrimSpace(body) == "" {
+		body = defaultWebhookBody
+	}
+
+	bodyTemplate, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return &webhookNotifier{body: bodyTemplate, config: config}, nil
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	var body bytes.Buffer
+	if err := n.body.Execute(&body, notification); err != nil {
+		return err
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	for name, value := range n.config.Headers {
+		headers[name] = value
+	}
+	return send(ctx, http.MethodPost, n.config.URL, headers, &body)
+}
+
+// ntfyNotifier publishes to the topic in the url of the config, like https://ntfy.sh/my-topic.
+type ntfyNotifier struct {
+	config NotifierConfig
+}
+
+func newNtfyNotifier(config NotifierConfig) (Notifier, error) {
+	return &ntfyNotifier{config: config}, nil
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, notification Notification) error {
+	headers := map[string]string{
+		"Title":    notification.Title(),
+		"Priority": "default",
+		"Tags":     "white_check_mark",
+		"Click":    notification.App.Link,
+	}
+	if !isUp(notification.To) {
+		headers["Priority"] = "high"
+		headers["Tags"] = "rotating_light"
+	}
+	if n.config.Token != "" {
+		headers["Authorization"] = "Bearer " + n.config.Token
+	}
+	for name, value := range n.config.Headers {
+		headers[name] = value
+	}
+	return send(ctx, http.MethodPost, n.config.URL, headers, strings.NewReader(notification.Message()))
+}
+
+// gotifyNotifier creates messages on a gotify server, with an application token.
+type gotifyNotifier struct {
+	config NotifierConfig
+}
+
+func newGotifyNotifier(config NotifierConfig) (Notifier, error) {
+	if config.Token == "" {
+		return nil, errors.New("gotify token is required")
+	}
+	return &gotifyNotifier{config: config}, nil
+}
+
+func (n *gotifyNotifier) Notify(ctx context.Context, notification Notification) error {
+	priority := 2
+	if !isUp(notification.To) {
+		priority = 8
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"title":    notification.Title(),
+		"message":  notification.Message(),
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{"Content-Type": "application/json", "X-Gotify-Key": n.config.Token}
+	return send(ctx, http.MethodPost, strings.TrimSuffix(n.config.URL, "/")+"/message", headers, bytes.NewReader(body))
+}
+
+// chatNotifier posts the message to incoming webhooks of chat services, which only differ in the name of the field.
+type chatNotifier struct {
+	field  string
+	config NotifierConfig
+}
+
+func newChatNotifier(field string) NotifierFactory {
+	return func(config NotifierConfig) (Notifier, error) {
+		return &chatNotifier{field: field, config: config}, nil
+	}
+}
+
+func (n *chatNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(map[string]string{n.field: notification.Message()})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	return send(ctx, http.MethodPost, n.config.URL, headers, bytes.NewReader(body))
+}
+
+// matrixNotifier sends text messages to a room, the url of the config being the homeserver.
+type matrixNotifier struct {
+	transactions atomic.Int64
+	config       NotifierConfig
+}
+
+func newMatrixNotifier(config NotifierConfig) (Notifier, error) {
+	if config.Token == "" || config.Room == "" {
+		return nil, errors.New("matrix token and room are required")
+	}
+	return &matrixNotifier{config: config}, nil
+}
+
+func (n *matrixNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": notification.Message()})
+	if err != nil {
+		return err
+	}
+
+	// matrix deduplicates messages by transaction id, so it must be unique for every message
+	transactionId := fmt.Sprintf("simplydash-%d-%d", time.Now().UnixNano(), n.transactions.Add(1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(n.config.URL, "/"), url.PathEscape(n.config.Room), transactionId)
+
+	headers := map[string]string{"Content-Type": "application/json", "Authorization": "Bearer " + n.config.Token}
+	return send(ctx, http.MethodPut, endpoint, headers, bytes.NewReader(body))
+}
+
+func send(ctx context.Context, method string, url string, headers map[string]string, body io.Reader) error {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer closeSafe(response.Body)
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("got status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+func toJSON(value any) (string, error) {
+	bytes, err := json.Marshal(value)
+	return string(bytes), err
+}
@@ -0,0 +1,362 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SMTPStartTLS    = "starttls"
+	SMTPImplicitTLS = "tls"
+	SMTPNoTLS       = "none"
+)
+
+var defaultSMTPPorts = map[string]int{
+	SMTPStartTLS:    587,
+	SMTPImplicitTLS: 465,
+	SMTPNoTLS:       25,
+}
+
+type SMTPConfig struct {
+	Host string `json:"host" yaml:"host"`
+	// Port defaults to 587 for starttls, 465 for tls and 25 for none.
+	Port int `json:"port" yaml:"port"`
+	// TLS is one of starttls (default), tls for implicit tls, or none.
+	TLS      string   `json:"tls"      yaml:"tls"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	From     string   `json:"from"     yaml:"from"`
+	To       []string `json:"to"       yaml:"to"`
+	// DownDelay holds down notifications back until the app has been down for that long,
+	// nothing is sent about apps which recover sooner.
+	DownDelay time.Duration `json:"down_delay" yaml:"down_delay"`
+	// DigestWindow batches the notifications within the window into a single email, starting with the first one.
+	DigestWindow time.Duration `json:"digest_window" yaml:"digest_window"`
+}
+
+// emailNotifier sends emails through an SMTP server. With a down delay or a digest window, notifications are sent
+// later from timers, so it must be closed to flush the digest and stop the timers.
+type emailNotifier struct {
+	tlsConfig *tls.Config
+	from      *mail.Address
+	to        []*mail.Address
+	// delivered is called with the delayed notifications once they are delivered
+	delivered func(Notification)
+	// pending holds the down notifications waiting for the down delay, by app
+	pending     map[string]*pendingNotification
+	digest      []Notification
+	digestTimer *time.Timer
+	logger      *slog.Logger
+	sending     sync.WaitGroup
+	config      SMTPConfig
+	mutex       sync.Mutex
+	closed      bool
+}
+
+type pendingNotification struct {
+	timer *time.Timer
+}
+
+func newEmailNotifier(config NotifierConfig) (Notifier, error) {
+	smtpConfig := config.SMTP
+	if smtpConfig.Host == "" {
+		return nil, errors.New("smtp host is required")
+	}
+
+	if smtpConfig.TLS == "" {
+		smtpConfig.TLS = SMTPStartTLS
+	}
+	defaultPort, ok := defaultSMTPPorts[smtpConfig.TLS]
+	if !ok {
+		return nil, fmt.Errorf("invalid smtp tls %s, expected one of starttls, tls or none", smtpConfig.TLS)
+	}
+	if smtpConfig.Port == 0 {
+		smtpConfig.Port = defaultPort
+	}
+
+	from, err := mail.ParseAddress(smtpConfig.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp from address: %w", err)
+	}
+
+	if len(smtpConfig.To) == 0 {
+		return nil, errors.New("smtp to addresses are required")
+	}
+	to := make([]*mail.Address, 0, len(smtpConfig.To))
+	for _, address := range smtpConfig.To {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp to address: %w", err)
+		}
+		to = append(to, parsed)
+	}
+
+	return &emailNotifier{
+		tlsConfig: &tls.Config{ServerName: smtpConfig.Host},
+		from:      from,
+		to:        to,
+		pending:   make(map[string]*pendingNotification),
+		logger:    slog.With("name", "email-notifier", "host", smtpConfig.Host),
+		config:    smtpConfig,
+	}, nil
+}
+
+// Notify returns errNotificationHeld for the notifications held back by the down delay or queued in the digest,
+// and for the up notifications dropped because the down one was never sent.
+func (n *emailNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.mutex.Lock()
+	if n.closed || n.config.DownDelay <= 0 {
+		n.mutex.Unlock()
+		return n.deliver(ctx, notification)
+	}
+
+	key := notification.App.Group + "/" + notification.App.Name
+	pending, isPending := n.pending[key]
+	if !isUp(notification.To) {
+		// the delay runs from the first failure, further ones don't extend it
+		if !isPending {
+			n.delay(key, notification)
+		}
+		n.mutex.Unlock()
+		return errNotificationHeld
+	}
+
+	if isPending {
+		pending.timer.Stop()
+		delete(n.pending, key)
+		n.mutex.Unlock()
+		return errNotificationHeld
+	}
+	n.mutex.Unlock()
+	return n.deliver(ctx, notification)
+}
+
+// delay delivers the down notification after the down delay, unless the app recovers first. The mutex must be held.
+func (n *emailNotifier) delay(key string, notification Notification) {
+	pending := &pendingNotification{}
+	n.pending[key] = pending
+
+	pending.timer = time.AfterFunc(n.config.DownDelay, func() {
+		n.mutex.Lock()
+		if n.closed || n.pending[key] != pending {
+			n.mutex.Unlock()
+			return
+		}
+		delete(n.pending, key)
+		delivered := n.delivered
+		n.sending.Add(1)
+		n.mutex.Unlock()
+		defer n.sending.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultNotificationTimeout)
+		defer cancel()
+
+		err := n.deliver(ctx, notification)
+		if errors.Is(err, errNotificationHeld) {
+			// the digest reports it once it is sent
+			return
+		}
+		if err != nil {
+			n.logger.Error("failed to send delayed notification", "app", notification.App.Name, "error", err)
+			return
+		}
+		if delivered != nil {
+			delivered(notification)
+		}
+	})
+}
+
+func (n *emailNotifier) onDelivered(delivered func(Notification)) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.delivered = delivered
+}
+
+// deliver sends the notification right away, or adds it to the digest, returning errNotificationHeld.
+func (n *emailNotifier) deliver(ctx context.Context, notification Notification) error {
+	n.mutex.Lock()
+	if n.closed || n.config.DigestWindow <= 0 {
+		n.mutex.Unlock()
+		return n.send(ctx, []Notification{notification})
+	}
+
+	n.digest = append(n.digest, notification)
+	if n.digestTimer == nil {
+		n.digestTimer = time.AfterFunc(n.config.DigestWindow, n.flushDigest)
+	}
+	n.mutex.Unlock()
+	return errNotificationHeld
+}
+
+func (n *emailNotifier) flushDigest() {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return
+	}
+	notifications := n.digest
+	n.digest = nil
+	n.digestTimer = nil
+	delivered := n.delivered
+	n.sending.Add(1)
+	n.mutex.Unlock()
+	defer n.sending.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultNotificationTimeout)
+	defer cancel()
+
+	err := n.sendDigest(ctx, notifications, delivered)
+	if err != nil {
+		n.logger.Error("failed to send digest", "notifications", len(notifications), "error", err)
+	}
+}
+
+// sendDigest reports the notifications of the digest as delivered once it is sent.
+func (n *emailNotifier) sendDigest(ctx context.Context, notifications []Notification, delivered func(Notification)) error {
+	if err := n.send(ctx, notifications); err != nil {
+		return err
+	}
+
+	if delivered != nil {
+		for _, notification := range notifications {
+			delivered(notification)
+		}
+	}
+	return nil
+}
+
+// Close drops the down notifications which are still delayed, and sends the digest right away.
+func (n *emailNotifier) Close() error {
+	n.mutex.Lock()
+	n.closed = true
+	for key, pending := range n.pending {
+		pending.timer.Stop()
+		delete(n.pending, key)
+	}
+	if n.digestTimer != nil {
+		n.digestTimer.Stop()
+	}
+	notifications := n.digest
+	n.digest = nil
+	delivered := n.delivered
+	n.mutex.Unlock()
+
+	n.sending.Wait()
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultNotificationTimeout)
+	defer cancel()
+	return n.sendDigest(ctx, notifications, delivered)
+}
+
+func (n *emailNotifier) send(ctx context.Context, notifications []Notification) error {
+	address := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	var conn net.Conn
+	var err error
+	if n.config.TLS == SMTPImplicitTLS {
+		conn, err = (&tls.Dialer{Config: n.tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	// net/smtp doesn't take a context, cancelling it interrupts the connection instead
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		closeSafe(conn)
+		return err
+	}
+	defer closeSafe(client)
+
+	if n.config.TLS == SMTPStartTLS {
+		// never fall back to plain text, the credentials would be sent in the clear
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server doesn't support STARTTLS")
+		}
+		if err = client.StartTLS(n.tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if n.config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(n.from.Address); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err = client.Rcpt(to.Address); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(n.message(notifications)); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *emailNotifier) message(notifications []Notification) []byte {
+	subject := notifications[0].Title()
+	var body strings.Builder
+	if len(notifications) == 1 {
+		notification := notifications[0]
+		fmt.Fprintf(&body, "%s\r\n\r\nLink: %s\r\nTime: %s\r\n",
+			notification.Message(), notification.App.Link, notification.Time.Format(time.RFC1123Z))
+	} else {
+		subject = fmt.Sprintf("%d health changes", len(notifications))
+		for _, notification := range notifications {
+			fmt.Fprintf(&body, "%s  %s\r\n", notification.Time.Format(time.RFC1123Z), notification.Message())
+		}
+	}
+
+	to := make([]string, 0, len(n.to))
+	for _, address := range n.to {
+		to = append(to, address.String())
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(&message)
+	_, _ = writer.Write([]byte(body.String()))
+	_ = writer.Close()
+	return message.Bytes()
+}
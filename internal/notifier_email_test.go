@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedEmail struct {
+	from    string
+	auth    string
+	to      []string
+	subject string
+	body    string
+	tls     bool
+}
+
+// smtpStandIn speaks just enough SMTP for net/smtp to send emails, with STARTTLS if it has a tls config.
+type smtpStandIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	emails    chan receivedEmail
+	implicit  bool
+}
+
+func newSMTPStandIn(t *testing.T, tlsConfig *tls.Config, implicit bool) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicit {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.Cleanup(func() { closeSafe(listener) })
+
+	server := &smtpStandIn{listener: listener, tlsConfig: tlsConfig, emails: make(chan receivedEmail, 10), implicit: implicit}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *smtpStandIn) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { closeSafe(conn) }()
+
+	text := textproto.NewConn(conn)
+	email := receivedEmail{tls: s.implicit}
+	reply := func(line string) bool { return text.PrintfLine("%s", line) == nil }
+
+	reply("220 127.0.0.1 ESMTP stand-in")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			reply("250-127.0.0.1")
+			if s.tlsConfig != nil && !email.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			email.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(argument, "PLAIN "))
+			email.auth = strings.ReplaceAll(strings.TrimPrefix(string(credentials), "\x00"), "\x00", ":")
+			reply("235 authenticated")
+		case "MAIL":
+			email.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			email.to = append(email.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			message, err := mail.ReadMessage(bufio.NewReader(text.DotReader()))
+			if err != nil {
+				return
+			}
+			email.subject, _ = new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+			body, _ := io.ReadAll(quotedprintable.NewReader(message.Body))
+			email.body = string(body)
+			reply("250 queued")
+			s.emails <- email
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// testCertificate returns the certificate of httptest, valid for 127.0.0.1, with a pool trusting it.
+func testCertificate(t *testing.T) (*tls.Config, *x509.CertPool) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return &tls.Config{Certificates: server.TLS.Certificates}, roots
+}
+
+func newTestEmailNotifier(t *testing.T, server *smtpStandIn, roots *x509.CertPool, config SMTPConfig) *emailNotifier {
+	config.Host = "127.0.0.1"
+	config.Port = server.port()
+	config.From = "simplydash <dash@home.arpa>"
+	config.To = []string{"ops@home.arpa", "Admin <admin@home.arpa>"}
+
+	notifier, err := newNotifier(NotifierConfig{Type: NotifierEmail, SMTP: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailNotifier := notifier.(*emailNotifier)
+	emailNotifier.tlsConfig.RootCAs = roots
+	t.Cleanup(func() { _ = emailNotifier.Close() })
+	return emailNotifier
+}
+
+func notificationOf(app string, to AppHealth) Notification {
+	from := Healthy
+	if isUp(to) {
+		from = Error
+	}
+	return Notification{Time: time.Now(), App: App{Name: app, Group: "group", Link: "https://" + app}, From: from, To: to}
+}
+
+func receivedEmails(server *smtpStandIn, wait time.Duration) []receivedEmail {
+	emails := make([]receivedEmail, 0)
+	for {
+		select {
+		case email := <-server.emails:
+			emails = append(emails, email)
+		case <-time.After(wait):
+			return emails
+		}
+	}
+}
+
+func TestEmailNotifier_send(t *testing.T) {
+	serverTLS, roots := testCertificate(t)
+	tests := []struct {
+		name     string
+		tls      string
+		implicit bool
+	}{
+		{name: "starttls", tls: SMTPStartTLS},
+		{name: "implicit tls", tls: SMTPImplicitTLS, implicit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPStandIn(t, serverTLS, tt.implicit)
+			notifier := newTestEmailNotifier(t, server, roots, SMTPConfig{TLS: tt.tls, Username: "user", Password: "secret"})
+
+			notification := notificationOf("app", Error)
+			notification.Error = "got status code 503"
+			assert.NoError(t, notifier.Notify(context.Background(), notification))
+
+			email := <-server.emails
+			assert.True(t, email.tls)
+			assert.Equal(t, "user:secret", email.auth)
+			assert.Equal(t, "dash@home.arpa", email.from)
+			assert.Equal(t, []string{"ops@home.arpa", "admin@home.arpa"}, email.to)
+			assert.Equal(t, "app is down", email.subject)
+			assert.Contains(t, email.body, "app (group) is error, was healthy: got status code 503")
+			assert.Contains(t, email.body, "Link: https://app")
+		})
+	}
+}
+
+func TestEmailNotifier_requiresStartTLS(t *testing.T) {
+	server := newSMTPStandIn(t, nil, false)
+	notifier := newTestEmailNotifier(t, server, nil, SMTPConfig{})
+	assert.EqualError(t, notifier.Notify(context.Background(), notificationOf("app", Error)),
+		"smtp server doesn't support STARTTLS")
+
+	notifier = newTestEmailNotifier(t, server, nil, SMTPConfig{TLS: SMTPNoTLS})
+	assert.NoError(t, notifier.Notify(context.Background(), notificationOf("app", Error)))
+	assert.False(t, (<-server.emails).tls)
+}
+
+func TestEmailNotifier_downDelay(t *testing.T) {
+	server := newSMTPStandIn(t, nil, false)
+	notifier := newTestEmailNotifier(t, server, nil, SMTPConfig{TLS: SMTPNoTLS, DownDelay: 100 * time.Millisecond})
+
+	assert.ErrorIs(t, notifier.Notify(context.Background(), notificationOf("flaky", Error)), errNotificationHeld)
+	assert.ErrorIs(t, notifier.Notify(context.Background(), notificationOf("flaky", Healthy)), errNotificationHeld)
+	assert.Empty(t, receivedEmails(server, 200*time.Millisecond), "recovering within the delay sends nothing")
+
+	assert.ErrorIs(t, notifier.Notify(context.Background(), notificationOf("down", Error)), errNotificationHeld)
+	emails := receivedEmails(server, 200*time.Millisecond)
+	if assert.Len(t, emails, 1) {
+		assert.Equal(t, "down is down", emails[0].subject)
+	}
+
+	assert.NoError(t, notifier.Notify(context.Background(), notificationOf("down", Healthy)))
+	assert.Equal(t, "down is up", (<-server.emails).subject)
+}
+
+func TestEmailNotifier_digest(t *testing.T) {
+	server := newSMTPStandIn(t, nil, false)
+	notifier := newTestEmailNotifier(t, server, nil, SMTPConfig{TLS: SMTPNoTLS, DigestWindow: 100 * time.Millisecond})
+	var delivered atomic.Int64
+	notifier.onDelivered(func(Notification) { delivered.Add(1) })
+
+	for i := range 3 {
+		assert.ErrorIs(t, notifier.Notify(context.Background(), notificationOf("app-"+strconv.Itoa(i), Timeout)), errNotificationHeld)
+	}
+	emails := receivedEmails(server, 200*time.Millisecond)
+	if assert.Len(t, emails, 1) {
+		assert.Equal(t, "3 health changes", emails[0].subject)
+		assert.Contains(t, emails[0].body, "app-0 (group) is timeout, was healthy")
+		assert.Contains(t, emails[0].body, "app-2 (group) is timeout, was healthy")
+	}
+	assert.Eventually(t, func() bool { return delivered.Load() == 3 }, time.Second, 10*time.Millisecond)
+
+	// closing sends the digest right away
+	assert.ErrorIs(t, notifier.Notify(context.Background(), notificationOf("app", Healthy)), errNotificationHeld)
+	assert.NoError(t, notifier.Close())
+	assert.Equal(t, "app is up", (<-server.emails).subject)
+	assert.Equal(t, int64(4), delivered.Load())
+}
+
+func Test_newEmailNotifier(t *testing.T) {
+	config := SMTPConfig{Host: "smtp.home.arpa", From: "dash@home.arpa", To: []string{"ops@home.arpa"}}
+	notifier, err := newNotifier(NotifierConfig{Type: NotifierEmail, SMTP: config})
+	assert.NoError(t, err)
+	assert.Equal(t, 587, notifier.(*emailNotifier).config.Port)
+
+	config.TLS = "ssl"
+	_, err = newNotifier(NotifierConfig{Type: NotifierEmail, SMTP: config})
+	assert.EqualError(t, err, "invalid smtp tls ssl, expected one of starttls, tls or none")
+
+	config.TLS = SMTPImplicitTLS
+	config.To = nil
+	_, err = newNotifier(NotifierConfig{Type: NotifierEmail, SMTP: config})
+	assert.EqualError(t, err, "smtp to addresses are required")
+
+	config.To = []string{"not an address"}
+	_, err = newNotifier(NotifierConfig{Type: NotifierEmail, SMTP: config})
+	assert.Error(t, err)
+}
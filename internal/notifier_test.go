@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedRequest struct {
+	header http.Header
+	method string
+	path   string
+	body   string
+}
+
+// newReceiver records the requests it receives, answering them with the status code.
+func newReceiver(t *testing.T, statusCode int) (*httptest.Server, <-chan receivedRequest) {
+	requests := make(chan receivedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- receivedRequest{header: r.Header, method: r.Method, path: r.URL.Path, body: string(body)}
+		w.WriteHeader(statusCode)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func testNotification() Notification {
+	return Notification{
+		Time:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		App:   App{Name: "app", Group: "group", Link: "https://app.local"},
+		Error: "got status code 503",
+		From:  Healthy,
+		To:    Error,
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config NotifierConfig
+		verify func(t *testing.T, request receivedRequest)
+	}{
+		{
+			name:   "webhook with the default body",
+			config: NotifierConfig{Type: NotifierWebhook, Headers: map[string]string{"X-Token": "secret"}},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.Equal(t, http.MethodPost, request.method)
+				assert.Equal(t, "secret", request.header.Get("X-Token"))
+				assert.JSONEq(t, `{"app": "app", "group": "group", "link": "https://app.local", "from": "healthy", `+
+					`"to": "error", "time": "2024-01-01T12:00:00Z", "error": "got status code 503", `+
+					`"message": "app (group) is error, was healthy: got status code 503"}`, request.body)
+			},
+		},
+		{
+			name:   "webhook with a templated body",
+			config: NotifierConfig{Type: NotifierWebhook, Body: `{"text": {{json .Title}}, "up": {{if eq .To.String "healthy"}}true{{else}}false{{end}}}`},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.JSONEq(t, `{"text": "app is down", "up": false}`, request.body)
+			},
+		},
+		{
+			name:   "ntfy",
+			config: NotifierConfig{Type: NotifierNtfy, Token: "token"},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.Equal(t, "app is down", request.header.Get("Title"))
+				assert.Equal(t, "high", request.header.Get("Priority"))
+				assert.Equal(t, "https://app.local", request.header.Get("Click"))
+				assert.Equal(t, "Bearer token", request.header.Get("Authorization"))
+				assert.Equal(t, "app (group) is error, was healthy: got status code 503", request.body)
+			},
+		},
+		{
+			name:   "gotify",
+			config: NotifierConfig{Type: NotifierGotify, Token: "token"},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.Equal(t, "/message", request.path)
+				assert.Equal(t, "token", request.header.Get("X-Gotify-Key"))
+				assert.JSONEq(t, `{"title": "app is down", "message": "app (group) is error, was healthy: got status code 503", `+
+					`"priority": 8}`, request.body)
+			},
+		},
+		{
+			name:   "discord",
+			config: NotifierConfig{Type: NotifierDiscord},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.JSONEq(t, `{"content": "app (group) is error, was healthy: got status code 503"}`, request.body)
+			},
+		},
+		{
+			name:   "slack",
+			config: NotifierConfig{Type: NotifierSlack},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.JSONEq(t, `{"text": "app (group) is error, was healthy: got status code 503"}`, request.body)
+			},
+		},
+		{
+			name:   "matrix",
+			config: NotifierConfig{Type: NotifierMatrix, Token: "token", Room: "!room:matrix.local"},
+			verify: func(t *testing.T, request receivedRequest) {
+				assert.Equal(t, http.MethodPut, request.method)
+				assert.Regexp(t, `^/_matrix/client/v3/rooms/!room:matrix.local/send/m.room.message/simplydash-\d+-1$`, request.path)
+				assert.Equal(t, "Bearer token", request.header.Get("Authorization"))
+
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal([]byte(request.body), &body))
+				assert.Equal(t, "m.text", body["msgtype"])
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newReceiver(t, http.StatusOK)
+			tt.config.URL = server.URL
+
+			notifier, err := newNotifier(tt.config)
+			assert.NoError(t, err)
+			assert.NoError(t, notifier.Notify(context.Background(), testNotification()))
+			tt.verify(t, <-requests)
+		})
+	}
+}
+
+func TestNotifier_errors(t *testing.T) {
+	server, _ := newReceiver(t, http.StatusInternalServerError)
+
+	notifier, err := newNotifier(NotifierConfig{Type: NotifierSlack, URL: server.URL})
+	assert.NoError(t, err)
+	assert.EqualError(t, notifier.Notify(context.Background(), testNotification()), "got status code 500")
+
+	_, err = newNotifier(NotifierConfig{Type: "pager", URL: server.URL})
+	assert.EqualError(t, err, "unsupported notifier type pager")
+	_, err = newNotifier(NotifierConfig{Type: NotifierWebhook, URL: "not a url"})
+	assert.Error(t, err)
+	_, err = newNotifier(NotifierConfig{Type: NotifierWebhook, URL: server.URL, Body: "{{"})
+	assert.Error(t, err)
+	_, err = newNotifier(NotifierConfig{Type: NotifierGotify, URL: server.URL})
+	assert.EqualError(t, err, "gotify token is required")
+	_, err = newNotifier(NotifierConfig{Type: NotifierMatrix, URL: server.URL, Token: "token"})
+	assert.EqualError(t, err, "matrix token and room are required")
+}
@@ -1,23 +1,203 @@
 package internal
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
 type Provider interface {
 	ID() string
 	Apps() []App
 	Init() error
+	// Stop cancels the goroutines of the provider, and waits for them to exit until the context expires.
+	Stop(ctx context.Context) error
+	// SyncStatus returns the time of the last successful sync, and the error of the last sync if it failed.
+	SyncStatus() (time.Time, error)
+	// Resync asks the provider to sync right away, instead of waiting for its next sync.
+	Resync()
+	// Crashes reports the failures which stop a running provider for good, until it is restarted.
+	Crashes() <-chan error
+	// Reset readies a stopped provider to be initialized again.
+	Reset()
 }
 
-func BuildProviders(config Config, notificationChan chan<- string) map[string]Provider {
+func BuildProviders(ctx context.Context, config Config, notificationChan chan<- string) map[string]Provider {
 	providers := make(map[string]Provider)
 
 	for providerName, providerConfig := range config.Providers.Docker {
-		provider := NewDockerProvider(providerName, providerConfig, notificationChan)
+		provider := NewDockerProvider(ctx, providerName, providerConfig, notificationChan)
 		providers[provider.ID()] = provider
 	}
 
 	for providerName, providerConfig := range config.Providers.File {
-		provider := NewFileProvider(providerName, providerConfig, notificationChan)
+		provider := NewFileProvider(ctx, providerName, providerConfig, notificationChan)
+		providers[provider.ID()] = provider
+	}
+
+	for providerName, providerConfig := range config.Providers.Kubernetes {
+		provider := NewKubernetesProvider(ctx, providerName, providerConfig, notificationChan)
+		providers[provider.ID()] = provider
+	}
+
+	for providerName, providerConfig := range config.Providers.Traefik {
+		provider := NewTraefikProvider(ctx, providerName, providerConfig, notificationChan)
+		providers[provider.ID()] = provider
+	}
+
+	for providerName, providerConfig := range config.Providers.HTTP {
+		provider := NewHTTPProvider(ctx, providerName, providerConfig, notificationChan)
 		providers[provider.ID()] = provider
 	}
 
 	return providers
 }
+
+// ProviderConfigs maps the ids of the providers built by BuildProviders to their configs,
+// to find out which providers changed between two configs.
+func ProviderConfigs(config Config) map[string]any {
+	configs := make(map[string]any)
+	addProviderConfigs(configs, "docker", config.Providers.Docker)
+	addProviderConfigs(configs, "file", config.Providers.File)
+	addProviderConfigs(configs, "kubernetes", config.Providers.Kubernetes)
+	addProviderConfigs(configs, "traefik", config.Providers.Traefik)
+	addProviderConfigs(configs, "http", config.Providers.HTTP)
+	return configs
+}
+
+func addProviderConfigs[T any](configs map[string]any, kind string, providerConfigs map[string]T) {
+	for name, providerConfig := range providerConfigs {
+		configs[providerId(kind, name)] = providerConfig
+	}
+}
+
+func providerId(kind string, name string) string {
+	return fmt.Sprintf("%s-%s", kind, name)
+}
+
+// providerRuntime tracks the goroutines of a provider, and the outcome of its syncs.
+// Providers embed it to get Stop, SyncStatus and Resync.
+type providerRuntime struct {
+	parent      context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	resyncCh    chan struct{}
+	crashCh     chan error
+	id          string
+	lastSync    time.Time
+	lastErr     error
+	wg          sync.WaitGroup
+	statusMutex sync.RWMutex
+}
+
+// newProviderRuntime ties the provider to ctx, so that it stops when ctx is cancelled.
+// The id of the provider labels its metrics.
+func newProviderRuntime(ctx context.Context, id string) *providerRuntime {
+	runCtx, cancel := context.WithCancel(ctx)
+	return &providerRuntime{
+		parent:   ctx,
+		ctx:      runCtx,
+		cancel:   cancel,
+		resyncCh: make(chan struct{}, 1),
+		crashCh:  make(chan error, 1),
+		id:       id,
+	}
+}
+
+// spawn runs fn in a goroutine which Stop waits for. The goroutines of a provider run until it is stopped,
+// so fn returning before that is reported as a crash.
+func (r *providerRuntime) spawn(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+		if r.ctx.Err() == nil {
+			r.crash(errors.New("provider stopped unexpectedly"))
+		}
+	}()
+}
+
+// crash reports a failure the provider can't recover from by itself. Only the first one is kept until Reset.
+func (r *providerRuntime) crash(err error) {
+	select {
+	case r.crashCh <- err:
+	default:
+	}
+}
+
+func (r *providerRuntime) Crashes() <-chan error {
+	return r.crashCh
+}
+
+// Reset must only be called once Stop returned, when none of the goroutines of the provider are left.
+func (r *providerRuntime) Reset() {
+	r.cancel()
+	r.ctx, r.cancel = context.WithCancel(r.parent)
+	select {
+	case <-r.crashCh:
+	default:
+	}
+}
+
+func (r *providerRuntime) Stop(ctx context.Context) error {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resync requests are coalesced, the sync loop of the provider reads them from resyncs.
+func (r *providerRuntime) Resync() {
+	select {
+	case r.resyncCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *providerRuntime) resyncs() <-chan struct{} {
+	return r.resyncCh
+}
+
+func (r *providerRuntime) SyncStatus() (time.Time, error) {
+	r.statusMutex.RLock()
+	defer r.statusMutex.RUnlock()
+	return r.lastSync, r.lastErr
+}
+
+// synced records a successful sync, which started at started.
+func (r *providerRuntime) synced(started time.Time) {
+	providerSyncDuration.WithLabelValues(r.id).Observe(time.Since(started).Seconds())
+
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	r.lastSync = time.Now()
+	r.lastErr = nil
+}
+
+func (r *providerRuntime) failed(err error) {
+	providerSyncErrorsTotal.WithLabelValues(r.id).Inc()
+
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+	r.lastErr = err
+}
+
+// notifyUpdate signals that the apps of a provider changed, unless the provider is stopped meanwhile.
+func notifyUpdate(ctx context.Context, notificationChan chan<- string, id string) {
+	select {
+	case notificationChan <- id:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type ProviderState string
+
+const (
+	ProviderStarting ProviderState = "starting"
+	ProviderRunning  ProviderState = "running"
+	ProviderFailed   ProviderState = "failed"
+	ProviderStopped  ProviderState = "stopped"
+)
+
+type ProviderStatus struct {
+	LastSync  *time.Time    `json:"last_sync"`
+	ID        string        `json:"id"`
+	State     ProviderState `json:"state"`
+	LastError string        `json:"last_error"`
+	// Restarts counts the failed attempts to initialize the provider, and the crashes of the running provider.
+	// Failed syncs are only reported in LastError, the provider retries those itself.
+	Restarts int `json:"restarts"`
+	Apps     int `json:"apps"`
+}
+
+// providerSupervisor initializes a provider, retrying with exponential backoff for as long as Init fails,
+// restarts it the same way when it crashes while running, and keeps track of the state of the provider.
+type providerSupervisor struct {
+	provider Provider
+	ctx      context.Context
+	cancel   context.CancelFunc
+	logger   *slog.Logger
+	err      error
+	state    ProviderState
+	restarts int
+	mutex    sync.RWMutex
+}
+
+func newProviderSupervisor(ctx context.Context, provider Provider) *providerSupervisor {
+	ctx, cancel := context.WithCancel(ctx)
+	return &providerSupervisor{
+		provider: provider,
+		ctx:      ctx,
+		cancel:   cancel,
+		state:    ProviderStarting,
+		logger:   slog.With("name", "provider-supervisor", "providerId", provider.ID()),
+	}
+}
+
+// start initializes the provider in the background, calling onStarted every time it succeeds.
+func (s *providerSupervisor) start(onStarted func()) {
+	go s.run(onStarted)
+}
+
+func (s *providerSupervisor) run(onStarted func()) {
+	backoff := DefaultProviderMinBackoff
+	for {
+		err := s.provider.Init()
+
+		s.mutex.Lock()
+		if s.ctx.Err() != nil {
+			s.mutex.Unlock()
+			return
+		}
+
+		if err == nil {
+			s.state = ProviderRunning
+			s.err = nil
+			s.mutex.Unlock()
+
+			onStarted()
+			started := time.Now()
+			if err = s.awaitCrash(); err == nil {
+				return
+			}
+			// only providers crashing soon after starting are backed off further
+			if time.Since(started) > DefaultProviderMaxBackoff {
+				backoff = DefaultProviderMinBackoff
+			}
+			s.mutex.Lock()
+		}
+
+		s.state = ProviderFailed
+		s.err = err
+		s.restarts++
+		s.mutex.Unlock()
+
+		s.logger.Error("provider failed", "error", err, "retryIn", backoff)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, DefaultProviderMaxBackoff)
+
+		if !s.reset() {
+			return
+		}
+	}
+}
+
+// awaitCrash blocks while the provider runs, returning the error it crashed with, or nil once it is stopped.
+// A crashed provider is stopped before returning.
+func (s *providerSupervisor) awaitCrash() error {
+	select {
+	case <-s.ctx.Done():
+		return nil
+	case err := <-s.provider.Crashes():
+		if stopErr := s.provider.Stop(s.ctx); stopErr != nil {
+			return nil
+		}
+		return err
+	}
+}
+
+// reset readies the provider for the next Init, unless the supervisor is stopped meanwhile.
+func (s *providerSupervisor) reset() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.provider.Reset()
+	return true
+}
+
+func (s *providerSupervisor) stop(ctx context.Context) error {
+	s.mutex.Lock()
+	s.cancel()
+	s.state = ProviderStopped
+	s.mutex.Unlock()
+
+	return s.provider.Stop(ctx)
+}
+
+func (s *providerSupervisor) status() ProviderStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := ProviderStatus{
+		ID:       s.provider.ID(),
+		State:    s.state,
+		Restarts: s.restarts,
+	}
+
+	lastSync, syncErr := s.provider.SyncStatus()
+	if !lastSync.IsZero() {
+		status.LastSync = &lastSync
+	}
+
+	err := s.err
+	if s.state == ProviderRunning && syncErr != nil {
+		status.State = ProviderFailed
+		err = syncErr
+	}
+
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider struct {
+	*providerRuntime
+	initErrs []error
+	id       string
+	apps     []App
+	mutex    sync.Mutex
+}
+
+func newFakeProvider(id string, initErrs ...error) *fakeProvider {
+	return &fakeProvider{
+		providerRuntime: newProviderRuntime(context.Background(), id),
+		id:              id,
+		initErrs:        initErrs,
+		apps:            make([]App, 0),
+	}
+}
+
+func (f *fakeProvider) ID() string {
+	return f.id
+}
+
+func (f *fakeProvider) Apps() []App {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.apps
+}
+
+func (f *fakeProvider) Init() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.initErrs) == 0 {
+		f.synced(time.Now())
+		return nil
+	}
+
+	err := f.initErrs[0]
+	f.initErrs = f.initErrs[1:]
+	return err
+}
+
+func TestProviderSupervisor_retriesFailedInit(t *testing.T) {
+	provider := newFakeProvider("fake-test", errors.New("connection refused"))
+	supervisor := newProviderSupervisor(context.Background(), provider)
+
+	started := make(chan struct{})
+	supervisor.start(func() { close(started) })
+
+	assert.Eventually(t, func() bool { return supervisor.status().State == ProviderFailed }, time.Second, 10*time.Millisecond)
+	status := supervisor.status()
+	assert.Equal(t, "connection refused", status.LastError)
+	assert.Equal(t, 1, status.Restarts)
+	assert.Nil(t, status.LastSync)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider was not restarted")
+	}
+
+	status = supervisor.status()
+	assert.Equal(t, ProviderRunning, status.State)
+	assert.Empty(t, status.LastError)
+	assert.NotNil(t, status.LastSync)
+
+	provider.failed(errors.New("sync failed"))
+	assert.Equal(t, ProviderFailed, supervisor.status().State)
+	assert.Equal(t, "sync failed", supervisor.status().LastError)
+
+	assert.NoError(t, supervisor.stop(context.Background()))
+	assert.Equal(t, ProviderStopped, supervisor.status().State)
+}
+
+func TestProviderSupervisor_restartsCrashedProvider(t *testing.T) {
+	provider := newFakeProvider("fake-test")
+	supervisor := newProviderSupervisor(context.Background(), provider)
+
+	started := make(chan struct{}, 2)
+	supervisor.start(func() { started <- struct{}{} })
+	<-started
+
+	// a goroutine of the provider exiting while it runs is a crash
+	provider.spawn(func() {})
+
+	assert.Eventually(t, func() bool { return supervisor.status().State == ProviderFailed }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "provider stopped unexpectedly", supervisor.status().LastError)
+	assert.Equal(t, 1, supervisor.status().Restarts)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("provider was not restarted")
+	}
+	assert.Equal(t, ProviderRunning, supervisor.status().State)
+
+	assert.NoError(t, supervisor.stop(context.Background()))
+	assert.Equal(t, ProviderStopped, supervisor.status().State)
+}
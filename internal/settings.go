@@ -1,14 +1,70 @@
 package internal
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 const Version = "dev"
 
 const (
-	DefaultDockerInterval = 10 * time.Second
-	DefaultDockerTimeout  = 5 * time.Second
+	DefaultDockerResyncInterval = 5 * time.Minute
+	DefaultDockerTimeout        = 5 * time.Second
+	DefaultDockerMinBackoff     = time.Second
+	DefaultDockerMaxBackoff     = time.Minute
+
+	DefaultKubernetesResyncInterval = 10 * time.Minute
+
+	DefaultTraefikGroup    = "Traefik"
+	DefaultTraefikInterval = time.Minute
+	DefaultTraefikTimeout  = 5 * time.Second
+
+	DefaultHTTPProviderInterval   = time.Minute
+	DefaultHTTPProviderTimeout    = 10 * time.Second
+	DefaultHTTPProviderMinBackoff = time.Second
+	DefaultHTTPProviderMaxBackoff = time.Minute
+
+	DefaultFileWatchDebounce   = 250 * time.Millisecond
+	DefaultFileWatchMinBackoff = time.Second
+	DefaultFileWatchMaxBackoff = time.Minute
+
+	DefaultProviderMinBackoff  = time.Second
+	DefaultProviderMaxBackoff  = 5 * time.Minute
+	DefaultProviderStopTimeout = 10 * time.Second
+
+	DefaultWebsocketCloseTimeout   = time.Second
+	DefaultWebsocketWriteTimeout   = 10 * time.Second
+	DefaultWebsocketPongTimeout    = time.Minute
+	DefaultWebsocketPingInterval   = DefaultWebsocketPongTimeout * 9 / 10
+	DefaultWebsocketMaxMessageSize = 4096
+
+	DefaultEventsHeartbeatInterval = 15 * time.Second
+	DefaultBroadcastQueueSize      = 256
+
+	DefaultAuthSessionTTL = 7 * 24 * time.Hour
 
 	DefaultEnableHealthcheck   = false
 	DefaultHealthcheckInterval = 10 * time.Second
 	DefaultHealthcheckTimeout  = 5 * time.Second
+
+	DefaultHealthcheckType            = HealthcheckHTTP
+	DefaultHealthcheckSource          = HealthSourceHTTP
+	DefaultHealthcheckCertExpiryDays  = 14
+	DefaultHealthcheckMethod          = http.MethodGet
+	DefaultHealthcheckFollowRedirects = true
+	DefaultHealthcheckMaxBodySize     = 1 << 20
+
+	DefaultHealthcheckRetryDelay       = time.Second
+	DefaultHealthcheckFailureThreshold = 1
+	DefaultHealthcheckSuccessThreshold = 1
+
+	DefaultHealthHistorySize            = 1000
+	DefaultHealthHistoryUptimeWindow    = 7 * 24 * time.Hour
+	DefaultHealthLatencySeriesSize      = 30
+	DefaultHealthHistoryPublishInterval = time.Minute
+	DefaultHealthHistorySaveInterval    = 5 * time.Minute
+	DefaultHealthTransitionBufferSize   = 64
+
+	DefaultNotificationCooldown = 15 * time.Minute
+	DefaultNotificationTimeout  = 10 * time.Second
 )
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraefikProviderConfig struct {
+	URL      string        `json:"url"      yaml:"url"`
+	Group    string        `json:"group"    yaml:"group"`
+	Include  string        `json:"include"  yaml:"include"`
+	Exclude  string        `json:"exclude"  yaml:"exclude"`
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	Timeout  time.Duration `json:"timeout"  yaml:"timeout"`
+}
+
+type traefikRouter struct {
+	TLS         *json.RawMessage `json:"tls"`
+	Name        string           `json:"name"`
+	Rule        string           `json:"rule"`
+	Status      string           `json:"status"`
+	EntryPoints []string         `json:"entryPoints"`
+}
+
+type traefikEntryPoint struct {
+	HTTP struct {
+		TLS *json.RawMessage `json:"tls"`
+	} `json:"http"`
+	Name string `json:"name"`
+}
+
+// TraefikProvider discovers apps from the routers known to a traefik instance.
+// Its apps are marked as discovered, so that apps with the same link from other providers take precedence.
+type TraefikProvider struct {
+	*providerRuntime
+	include          *regexp.Regexp
+	exclude          *regexp.Regexp
+	logger           *slog.Logger
+	notificationChan chan<- string
+	client           *http.Client
+	id               string
+	apps             []App
+	config           TraefikProviderConfig
+	mutex            sync.RWMutex
+}
+
+func NewTraefikProvider(ctx context.Context, name string, config TraefikProviderConfig, notificationChan chan<- string) Provider {
+	id := providerId("traefik", name)
+	return &TraefikProvider{
+		providerRuntime:  newProviderRuntime(ctx, id),
+		id:               id,
+		apps:             make([]App, 0),
+		config:           config,
+		client:           &http.Client{},
+		notificationChan: notificationChan,
+		logger:           slog.With("id", id),
+	}
+}
+
+func (tp *TraefikProvider) ID() string {
+	return tp.id
+}
+
+func (tp *TraefikProvider) Apps() []App {
+	tp.mutex.RLock()
+	defer tp.mutex.RUnlock()
+	return tp.apps
+}
+
+func (tp *TraefikProvider) Init() error {
+	if _, err := url.ParseRequestURI(tp.config.URL); err != nil {
+		return fmt.Errorf("invalid traefik url: %w", err)
+	}
+
+	var err error
+	if tp.include, err = compileOptionalRegex(tp.config.Include); err != nil {
+		return fmt.Errorf("invalid include regex: %w", err)
+	}
+	if tp.exclude, err = compileOptionalRegex(tp.config.Exclude); err != nil {
+		return fmt.Errorf("invalid exclude regex: %w", err)
+	}
+
+	if tp.config.Group == "" {
+		tp.config.Group = DefaultTraefikGroup
+	}
+	if tp.config.Interval <= 0 {
+		tp.config.Interval = DefaultTraefikInterval
+	}
+	if tp.config.Timeout <= 0 {
+		tp.config.Timeout = DefaultTraefikTimeout
+	}
+	tp.client.Timeout = tp.config.Timeout
+
+	tp.spawn(func() {
+		tp.fetch()
+		tp.poll()
+	})
+	return nil
+}
+
+func (tp *TraefikProvider) poll() {
+	ticker := time.NewTicker(tp.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tp.ctx.Done():
+			return
+		case <-ticker.C:
+			tp.fetch()
+		case <-tp.resyncs():
+			tp.fetch()
+		}
+	}
+}
+
+func (tp *TraefikProvider) fetch() {
+	started := time.Now()
+	routers := make([]traefikRouter, 0)
+	if err := tp.get("/api/http/routers", &routers); err != nil {
+		tp.logger.Error("fetching routers", "error", err)
+		tp.failed(err)
+		return
+	}
+
+	entryPoints := make([]traefikEntryPoint, 0)
+	if err := tp.get("/api/entrypoints", &entryPoints); err != nil {
+		tp.logger.Error("fetching entrypoints", "error", err)
+		tp.failed(err)
+		return
+	}
+
+	tlsEntryPoints := make([]string, 0)
+	for _, entryPoint := range entryPoints {
+		if entryPoint.HTTP.TLS != nil {
+			tlsEntryPoints = append(tlsEntryPoints, entryPoint.Name)
+		}
+	}
+
+	apps := make([]App, 0)
+	for _, router := range routers {
+		app, ok := tp.routerToApp(router, tlsEntryPoints)
+		if !ok {
+			continue
+		}
+
+		if errs := app.Validate(); len(errs) > 0 {
+			tp.logger.Debug("skipping router", "router", router.Name, "error", errs)
+			continue
+		}
+		apps = insertOrdered(apps, app)
+	}
+
+	tp.mutex.Lock()
+	changed := !reflect.DeepEqual(tp.apps, apps)
+	tp.apps = apps
+	tp.mutex.Unlock()
+	tp.synced(started)
+
+	if changed {
+		notifyUpdate(tp.ctx, tp.notificationChan, tp.id)
+	}
+}
+
+func (tp *TraefikProvider) routerToApp(router traefikRouter, tlsEntryPoints []string) (App, bool) {
+	if router.Status != "" && router.Status != "enabled" {
+		return App{}, false
+	}
+
+	if tp.include != nil && !tp.include.MatchString(router.Name) {
+		return App{}, false
+	}
+
+	if tp.exclude != nil && tp.exclude.MatchString(router.Name) {
+		return App{}, false
+	}
+
+	host := hostFromRule(router.Rule)
+	if host == "" {
+		return App{}, false
+	}
+
+	secure := router.TLS != nil
+	for _, entryPoint := range router.EntryPoints {
+		secure = secure || slices.Contains(tlsEntryPoints, entryPoint)
+	}
+
+	// router names are qualified with the traefik provider, like "whoami@docker"
+	name, _, _ := strings.Cut(router.Name, "@")
+	return App{
+		Name:        name,
+		Link:        buildLink(secure, host, ""),
+		Group:       tp.config.Group,
+		Healthcheck: AppHealthcheck{Health: Unknown},
+		Discovered:  true,
+	}, true
+}
+
+func (tp *TraefikProvider) get(path string, target any) error {
+	ctx, cancel := context.WithTimeout(tp.ctx, tp.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(tp.config.URL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := tp.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer closeSafe(response.Body)
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("got status code %d", response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+func compileOptionalRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
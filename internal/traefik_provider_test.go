@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraefikProvider_fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/http/routers":
+			_, _ = w.Write([]byte(`[
+				{"name": "whoami@docker", "rule": "Host(` + "`whoami.example.com`" + `)", "entryPoints": ["websecure"], "status": "enabled"},
+				{"name": "plain@file", "rule": "Host(` + "`plain.example.com`" + `)", "entryPoints": ["web"], "status": "enabled"},
+				{"name": "secure@file", "rule": "Host(` + "`secure.example.com`" + `)", "entryPoints": ["web"], "tls": {}, "status": "enabled"},
+				{"name": "api@internal", "rule": "PathPrefix(` + "`/api`" + `)", "entryPoints": ["traefik"], "status": "enabled"},
+				{"name": "hidden@docker", "rule": "Host(` + "`hidden.example.com`" + `)", "entryPoints": ["web"], "status": "enabled"},
+				{"name": "broken@docker", "rule": "Host(` + "`broken.example.com`" + `)", "entryPoints": ["web"], "status": "disabled"}
+			]`))
+		case "/api/entrypoints":
+			_, _ = w.Write([]byte(`[{"name": "web"}, {"name": "websecure", "http": {"tls": {}}}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	notificationChan := make(chan string, 1)
+	provider := NewTraefikProvider(context.Background(), "test", TraefikProviderConfig{
+		URL:     server.URL,
+		Exclude: "^hidden@",
+	}, notificationChan).(*TraefikProvider)
+
+	assert.NoError(t, provider.Init())
+	<-notificationChan
+
+	links := make(map[string]string)
+	for _, app := range provider.Apps() {
+		assert.True(t, app.Discovered)
+		assert.Equal(t, DefaultTraefikGroup, app.Group)
+		links[app.Name] = app.Link
+	}
+
+	assert.Equal(t, map[string]string{
+		"whoami": "https://whoami.example.com",
+		"plain":  "http://plain.example.com",
+		"secure": "https://secure.example.com",
+	}, links)
+}
@@ -3,8 +3,10 @@ package internal
 import (
 	"context"
 	"log/slog"
+	"net/url"
 	"os"
 	"sort"
+	"strings"
 )
 
 func insertOrdered(apps []App, app App) []App {
@@ -19,6 +21,19 @@ func insertOrdered(apps []App, app App) []App {
 	return apps
 }
 
+// normalizeLink makes links comparable, ignoring the case of the host and any trailing slash.
+func normalizeLink(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
 func SetupSlog(args Args) {
 	level := slog.LevelInfo
 	err := level.UnmarshalText([]byte(args.Log.Level))
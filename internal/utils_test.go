@@ -43,3 +43,19 @@ func Test_insertOrdered(t *testing.T) {
 		})
 	}
 }
+
+func Test_normalizeLink(t *testing.T) {
+	cases := map[string]string{
+		"https://Example.com/":     "https://example.com",
+		"HTTPS://example.com/app/": "https://example.com/app",
+		"http://example.com:8080":  "http://example.com:8080",
+	}
+
+	for link, expected := range cases {
+		t.Run(link, func(t *testing.T) {
+			if result := normalizeLink(link); result != expected {
+				t.Errorf("Expected %s - Got %s", expected, result)
+			}
+		})
+	}
+}
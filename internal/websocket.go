@@ -1,110 +1,261 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// WebsocketMessage wraps every message sent to clients. The first one is a snapshot of the apps and settings,
+// followed by app deltas with consecutive seqs. Clients seeing a gap in the seqs missed a message,
+// and need a new snapshot. Replies to commands are not part of the sequence, and have no seq.
+type WebsocketMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	Seq  uint64          `json:"seq,omitempty"`
+}
+
 type WebsocketServer struct {
+	ctx         context.Context
+	broadcaster *Broadcaster
 	appService  AppService
-	connections map[string]*WebsocketConnection
 	logger      *slog.Logger
+	wg          sync.WaitGroup
+	lastId      atomic.Uint64
 }
 
-func NewWebsocketServer(appService AppService) *WebsocketServer {
+// NewWebsocketServer creates a server whose connections are closed once ctx is cancelled.
+// Commands of the clients are run against appService.
+func NewWebsocketServer(ctx context.Context, broadcaster *Broadcaster, appService AppService) *WebsocketServer {
 	return &WebsocketServer{
+		ctx:         ctx,
+		broadcaster: broadcaster,
 		appService:  appService,
-		connections: make(map[string]*WebsocketConnection),
 		logger:      slog.With("name", "websocket-server"),
 	}
 }
 
-func (ws *WebsocketServer) Init() {
-	ws.logger.Debug("init")
-	go ws.run()
-}
+// errSessionEnded closes the websockets opened with a session which ended.
+var errSessionEnded = errors.New("session ended")
 
-func (ws *WebsocketServer) Connect(id string, conn *websocket.Conn) {
-	ws.logger.Debug("client connected", "id", id)
-	connection := NewWebsocketConnection(id, conn)
-	connection.Init(ws.getAppsAsString())
-	ws.connections[id] = connection
+// Connect serves a client until it disconnects, stops answering pings, the server shuts down,
+// or session is cancelled.
+func (ws *WebsocketServer) Connect(session context.Context, conn *websocket.Conn) {
+	id := strconv.FormatUint(ws.lastId.Add(1), 10)
+	ws.logger.Debug("client connected", "id", id, "remoteAddr", conn.RemoteAddr())
+	ctx, cancel := context.WithCancelCause(ws.ctx)
+	stopSession := context.AfterFunc(session, func() { cancel(errSessionEnded) })
+	subscription := ws.broadcaster.SubscribeDeltas()
+	connection := NewWebsocketConnection(ctx, id, conn, ws.broadcaster, subscription, ws.appService)
+	ws.wg.Add(1)
+	websocketClients.Inc()
+	connection.Init(func() {
+		ws.logger.Debug("client disconnected", "id", id)
+		stopSession()
+		cancel(nil)
+		ws.broadcaster.Unsubscribe(subscription)
+		websocketClients.Dec()
+		ws.wg.Done()
+	})
 }
 
-func (ws *WebsocketServer) run() {
-	for {
-		<-ws.appService.UpdateCh()
-		ws.logger.Debug("update received")
-		go ws.notifyConnections()
-	}
-}
+// Shutdown waits for all connections to be closed after the context of the server is cancelled.
+func (ws *WebsocketServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
 
-func (ws *WebsocketServer) getAppsAsString() string {
-	apps := ws.appService.GetApps()
-
-	bytes, err := json.Marshal(apps)
-	if err != nil {
-		ws.logger.Error("marshalling json", "error", err)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	return string(bytes)
+type WebsocketConnection struct {
+	ctx          context.Context
+	conn         *websocket.Conn
+	broadcaster  *Broadcaster
+	subscription *Subscription
+	appService   AppService
+	commandCh    chan WebsocketCommand
+	writeDone    chan struct{}
+	logger       *slog.Logger
+	id           string
+	// view filters the apps sent to the client, owned by the write pump
+	view *websocketView
+	// seq numbers the messages sent to the client, as filtering leaves gaps in the seqs of the broadcaster
+	seq uint64
 }
 
-func (ws *WebsocketServer) notifyConnections() {
-	jsonString := ws.getAppsAsString()
-	for _, conn := range ws.connections {
-		ws.logger.Debug("sending message", "connectionId", conn.id)
-		conn.updateCh <- jsonString
+func NewWebsocketConnection(
+	ctx context.Context,
+	id string,
+	conn *websocket.Conn,
+	broadcaster *Broadcaster,
+	subscription *Subscription,
+	appService AppService,
+) *WebsocketConnection {
+	return &WebsocketConnection{
+		ctx:          ctx,
+		id:           id,
+		conn:         conn,
+		broadcaster:  broadcaster,
+		subscription: subscription,
+		appService:   appService,
+		commandCh:    make(chan WebsocketCommand),
+		writeDone:    make(chan struct{}),
+		logger:       slog.With("name", "websocket-connection", "id", id),
 	}
 }
 
-type WebsocketConnection struct {
-	conn     *websocket.Conn
-	updateCh chan string
-	stopCh   chan struct{}
-	logger   *slog.Logger
-	id       string
+// Init starts the goroutines owning the connection, and calls onClosed once both are done.
+// The websocket connection supports a single concurrent reader and writer, so all reads happen in the read pump,
+// and all writes in the write pump, which sends the events of the subscription, the pings and the replies
+// to the commands.
+func (wc *WebsocketConnection) Init(onClosed func()) {
+	go func() {
+		defer onClosed()
+
+		readDone := make(chan struct{})
+		go func() {
+			defer close(readDone)
+			wc.readPump()
+		}()
+
+		wc.writePump(readDone)
+		// unblocks the read pump, if the write pump stopped first
+		close(wc.writeDone)
+		_ = wc.conn.Close()
+		<-readDone
+	}()
 }
 
-func NewWebsocketConnection(id string, conn *websocket.Conn) *WebsocketConnection {
-	return &WebsocketConnection{
-		id:       id,
-		conn:     conn,
-		updateCh: make(chan string, 1),
-		stopCh:   make(chan struct{}, 1),
-		logger:   slog.With("name", "websocket-connection", "id", id),
+// readPump reads the commands of the client until the connection fails, which is how closed connections
+// are noticed. Any message or pong from the client keeps the connection alive.
+func (wc *WebsocketConnection) readPump() {
+	wc.conn.SetReadLimit(DefaultWebsocketMaxMessageSize)
+	extendDeadline := func() error {
+		return wc.conn.SetReadDeadline(time.Now().Add(DefaultWebsocketPongTimeout))
 	}
-}
+	_ = extendDeadline()
+	wc.conn.SetPongHandler(func(string) error { return extendDeadline() })
 
-func (wc *WebsocketConnection) Init(message string) {
-	go wc.sendMessage(message)
-	go wc.run()
+	for {
+		_, message, err := wc.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				wc.logger.Debug("reading message", "error", err)
+			}
+			return
+		}
+		_ = extendDeadline()
+
+		var command WebsocketCommand
+		if err := json.Unmarshal(message, &command); err != nil {
+			command = WebsocketCommand{Type: commandInvalid}
+		}
+
+		select {
+		case wc.commandCh <- command:
+		case <-wc.writeDone:
+			return
+		}
+	}
 }
 
-func (wc *WebsocketConnection) run() {
-	defer func(ws *websocket.Conn) {
-		_ = ws.Close()
-	}(wc.conn)
+func (wc *WebsocketConnection) writePump(readDone <-chan struct{}) {
+	ping := time.NewTicker(DefaultWebsocketPingInterval)
+	defer ping.Stop()
 
 	for {
 		select {
-		case <-wc.stopCh:
+		case <-wc.ctx.Done():
 			wc.logger.Debug("closing connection")
+			wc.sendClose(context.Cause(wc.ctx))
 			return
-		case message := <-wc.updateCh:
+		case <-readDone:
+			wc.logger.Debug("connection closed")
+			return
+		case <-ping.C:
+			err := wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(DefaultWebsocketWriteTimeout))
+			if err != nil {
+				wc.logger.Debug("writing ping", "error", err)
+				return
+			}
+		case command := <-wc.commandCh:
+			if !wc.sendMessage(wc.handleCommand(command)) {
+				return
+			}
+		case <-wc.subscription.Notify():
 			wc.logger.Debug("got update")
-			go wc.sendMessage(message)
+			for _, event := range wc.subscription.Events() {
+				for _, message := range wc.filter(event) {
+					wc.seq++
+					message.Seq = wc.seq
+					if !wc.sendMessage(message) {
+						return
+					}
+				}
+			}
 		}
 	}
 }
 
-func (wc *WebsocketConnection) sendMessage(message string) {
-	wc.logger.Debug("sending message to websocket")
-	err := wc.conn.WriteMessage(websocket.TextMessage, []byte(message))
+// filter passes the event through the view of the client, if it subscribed to some of the apps.
+func (wc *WebsocketConnection) filter(event BroadcastEvent) []WebsocketMessage {
+	if wc.view == nil {
+		return []WebsocketMessage{{Type: event.Type, Data: event.Data}}
+	}
+
+	messages, err := wc.view.apply(event)
+	if err != nil {
+		wc.logger.Error("filtering event", "type", event.Type, "error", err)
+		// the view is out of sync, start over from a snapshot
+		wc.broadcaster.Resync(wc.subscription)
+		return nil
+	}
+	return messages
+}
+
+// sendClose tells the client that the server is going away, so that it can reconnect later,
+// or that its session ended, so that it logs in again.
+func (wc *WebsocketConnection) sendClose(cause error) {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	if errors.Is(cause, errSessionEnded) {
+		message = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, errSessionEnded.Error())
+	}
+	err := wc.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(DefaultWebsocketCloseTimeout))
+	if err != nil {
+		wc.logger.Debug("writing close message", "error", err)
+	}
+}
+
+// sendMessage gives up after DefaultWebsocketWriteTimeout, so that clients which stopped reading
+// don't hold the connection forever.
+func (wc *WebsocketConnection) sendMessage(message WebsocketMessage) bool {
+	wc.logger.Debug("sending message to websocket", "type", message.Type)
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		wc.logger.Error("marshalling json", "error", err)
+		return false
+	}
+
+	_ = wc.conn.SetWriteDeadline(time.Now().Add(DefaultWebsocketWriteTimeout))
+	err = wc.conn.WriteMessage(websocket.TextMessage, bytes)
 	if err != nil {
 		wc.logger.Error("writing message", "error", err)
-		wc.stopCh <- struct{}{}
+		return false
 	}
+	return true
 }
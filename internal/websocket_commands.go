@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	// commandSubscribe limits the apps sent to the client to some groups or tags, or lifts the limit if both are empty.
+	commandSubscribe = "subscribe"
+	// commandSnapshot asks for a new snapshot, after missing a message.
+	commandSnapshot = "snapshot"
+	// commandRecheck runs the health check of an app right away.
+	commandRecheck = "recheck"
+	// commandResync asks a provider to sync right away.
+	commandResync = "resync"
+	// commandInvalid stands for messages which aren't valid commands.
+	commandInvalid = ""
+
+	replyAck   = "ack"
+	replyError = "error"
+)
+
+// WebsocketCommand is sent by clients, and answered with an ack or an error carrying the same id.
+type WebsocketCommand struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type subscribeCommand struct {
+	Groups []string `json:"groups"`
+	Tags   []string `json:"tags"`
+}
+
+type recheckCommand struct {
+	ID string `json:"id"`
+}
+
+type resyncCommand struct {
+	Provider string `json:"provider"`
+}
+
+type commandReply struct {
+	ID      string `json:"id"`
+	Message string `json:"message,omitempty"`
+}
+
+func (wc *WebsocketConnection) handleCommand(command WebsocketCommand) WebsocketMessage {
+	wc.logger.Debug("got command", "type", command.Type, "commandId", command.ID)
+	err := wc.runCommand(command)
+
+	reply := WebsocketMessage{Type: replyAck}
+	replyData := commandReply{ID: command.ID}
+	if err != nil {
+		reply.Type = replyError
+		replyData.Message = err.Error()
+	}
+
+	reply.Data, _ = json.Marshal(replyData)
+	return reply
+}
+
+func (wc *WebsocketConnection) runCommand(command WebsocketCommand) error {
+	switch command.Type {
+	case commandSubscribe:
+		var data subscribeCommand
+		if err := unmarshalCommand(command, &data); err != nil {
+			return err
+		}
+
+		filter := appFilter{groups: data.Groups, tags: data.Tags}
+		if filter.empty() {
+			wc.view = nil
+		} else {
+			wc.view = &websocketView{filter: filter}
+		}
+		// the snapshot is sent after the ack, as replies are sent right away
+		wc.broadcaster.Resync(wc.subscription)
+		return nil
+	case commandSnapshot:
+		wc.broadcaster.Resync(wc.subscription)
+		return nil
+	case commandRecheck:
+		var data recheckCommand
+		if err := unmarshalCommand(command, &data); err != nil {
+			return err
+		}
+		return wc.appService.Recheck(data.ID)
+	case commandResync:
+		var data resyncCommand
+		if err := unmarshalCommand(command, &data); err != nil {
+			return err
+		}
+		return wc.appService.Resync(data.Provider)
+	case commandInvalid:
+		return errors.New("invalid command")
+	default:
+		return fmt.Errorf("unknown command %s", command.Type)
+	}
+}
+
+func unmarshalCommand(command WebsocketCommand, data any) error {
+	if len(command.Data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(command.Data, data); err != nil {
+		return fmt.Errorf("invalid %s command: %w", command.Type, err)
+	}
+	return nil
+}
+
+// websocketView keeps all the apps, to turn the events of the broadcaster into the events of the apps matching
+// the filter. Apps which start or stop matching the filter are added or removed.
+type websocketView struct {
+	filter    appFilter
+	appGroups []AppGroup
+}
+
+func (v *websocketView) apply(event BroadcastEvent) ([]WebsocketMessage, error) {
+	if event.Type == broadcastSnapshot {
+		return v.applySnapshot(event)
+	}
+
+	delta := AppDelta{Type: event.Type}
+	if err := json.Unmarshal(event.Data, &delta); err != nil {
+		return nil, err
+	}
+
+	before, _, ok := v.find(delta.ID, delta.Group)
+	visibleBefore := ok && v.filter.matches(before)
+	if !applyAppDelta(v.appGroups, delta) {
+		return nil, fmt.Errorf("%s delta for unknown app %s", delta.Type, delta.ID)
+	}
+	after, index, ok := v.find(delta.ID, delta.Group)
+	visibleAfter := ok && v.filter.matches(after)
+
+	switch {
+	case visibleBefore && visibleAfter:
+		return []WebsocketMessage{{Type: event.Type, Data: event.Data}}, nil
+	case visibleBefore:
+		return deltaMessages(AppDelta{Type: deltaAppRemoved, ID: delta.ID, Group: delta.Group})
+	case visibleAfter:
+		return deltaMessages(AppDelta{Type: deltaAppAdded, ID: delta.ID, Group: delta.Group, Index: index, App: &after})
+	default:
+		return nil, nil
+	}
+}
+
+func (v *websocketView) applySnapshot(event BroadcastEvent) ([]WebsocketMessage, error) {
+	var snapshot BroadcastSnapshot
+	if err := json.Unmarshal(event.Data, &snapshot); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(snapshot.Apps, &v.appGroups); err != nil {
+		return nil, err
+	}
+
+	// groups left out by the filter never get any delta, the other ones are kept even if empty,
+	// as deltas may add apps to them
+	filtered := make([]AppGroup, 0)
+	for _, group := range v.appGroups {
+		if len(v.filter.groups) > 0 && !slices.ContainsFunc(v.filter.groups, equalFold(group.Name)) {
+			continue
+		}
+
+		filteredGroup := NewAppGroup(group.Name)
+		for _, app := range group.Apps {
+			if v.filter.matches(app) {
+				filteredGroup.Apps = append(filteredGroup.Apps, app)
+			}
+		}
+		filtered = append(filtered, filteredGroup)
+	}
+
+	apps, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(BroadcastSnapshot{Settings: snapshot.Settings, Apps: apps})
+	if err != nil {
+		return nil, err
+	}
+	return []WebsocketMessage{{Type: broadcastSnapshot, Data: data}}, nil
+}
+
+// find returns an app, with its index among the apps of its group matching the filter.
+func (v *websocketView) find(id string, groupName string) (App, int, bool) {
+	for _, group := range v.appGroups {
+		if group.Name != groupName {
+			continue
+		}
+
+		index := 0
+		for _, app := range group.Apps {
+			if app.ID == id {
+				return app, index, true
+			}
+			if v.filter.matches(app) {
+				index++
+			}
+		}
+	}
+	return App{}, 0, false
+}
+
+func deltaMessages(delta AppDelta) ([]WebsocketMessage, error) {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return nil, err
+	}
+	return []WebsocketMessage{{Type: delta.Type, Data: data}}, nil
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// commandAppService records the recheck and resync commands.
+type commandAppService struct {
+	*fakeAppService
+	rechecked []string
+	resynced  []string
+	mutex     sync.Mutex
+}
+
+func (c *commandAppService) Recheck(appId string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if appId != "known" {
+		return fmt.Errorf("unknown app %s", appId)
+	}
+	c.rechecked = append(c.rechecked, appId)
+	return nil
+}
+
+func (c *commandAppService) Resync(providerId string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.resynced = append(c.resynced, providerId)
+	return nil
+}
+
+type testWebsocketServer struct {
+	*WebsocketServer
+	appService  *fakeAppService
+	commands    *commandAppService
+	broadcaster *Broadcaster
+	url         string
+}
+
+func newTestWebsocketServer(t *testing.T) testWebsocketServer {
+	broadcaster, appService := newTestBroadcaster(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	commands := &commandAppService{fakeAppService: appService}
+	websocketServer := NewWebsocketServer(ctx, broadcaster, commands)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		websocketServer.Connect(context.Background(), conn)
+	}))
+	t.Cleanup(server.Close)
+
+	return testWebsocketServer{
+		WebsocketServer: websocketServer,
+		appService:      appService,
+		commands:        commands,
+		broadcaster:     broadcaster,
+		url:             "ws" + strings.TrimPrefix(server.URL, "http"),
+	}
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) WebsocketMessage {
+	t.Helper()
+	var message WebsocketMessage
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.ReadJSON(&message))
+	return message
+}
+
+func TestWebsocketConnection_commands(t *testing.T) {
+	server := newTestWebsocketServer(t)
+	appService, commands := server.appService, server.commands
+
+	conn, _, err := websocket.DefaultDialer.Dial(server.url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer closeSafe(conn)
+	assert.Equal(t, broadcastSnapshot, readMessage(t, conn).Type)
+
+	appService.update(deltaTestGroups(), AppConfig{})
+	assert.Equal(t, WebsocketMessage{Type: broadcastSnapshot, Seq: 2,
+		Data: json.RawMessage(`{"settings":{"name":"dash","groups":null},"apps":[{"name":"a","apps":[]},{"name":"b","apps":[]}]}`)},
+		readMessage(t, conn))
+
+	for _, test := range []struct {
+		command string
+		reply   string
+	}{
+		{`{"id": "1", "type": "recheck", "data": {"id": "known"}}`, `{"type":"ack","data":{"id":"1"}}`},
+		{`{"id": "2", "type": "recheck", "data": {"id": "other"}}`, `{"type":"error","data":{"id":"2","message":"unknown app other"}}`},
+		{`{"id": "3", "type": "resync", "data": {"provider": "docker-local"}}`, `{"type":"ack","data":{"id":"3"}}`},
+		{`{"id": "4", "type": "reboot"}`, `{"type":"error","data":{"id":"4","message":"unknown command reboot"}}`},
+		{`{"id": "5", "type": "recheck", "data": "known"}`, `{"type":"error","data":{"id":"5","message":"invalid recheck command: json: cannot unmarshal string into Go value of type internal.recheckCommand"}}`},
+		{`not json`, `{"type":"error","data":{"id":"","message":"invalid command"}}`},
+	} {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(test.command)))
+		_, reply, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.JSONEq(t, test.reply, string(reply), test.command)
+	}
+	assert.Equal(t, []string{"known"}, commands.rechecked)
+	assert.Equal(t, []string{"docker-local"}, commands.resynced)
+
+	assert.NoError(t, conn.WriteJSON(WebsocketCommand{ID: "6", Type: commandSubscribe, Data: json.RawMessage(`{"groups": ["A"]}`)}))
+	assert.Equal(t, replyAck, readMessage(t, conn).Type)
+	filtered := readMessage(t, conn)
+	assert.Equal(t, broadcastSnapshot, filtered.Type)
+	assert.Equal(t, uint64(3), filtered.Seq)
+	assert.JSONEq(t, `{"settings":{"name":"dash","groups":null},"apps":[{"name":"a","apps":[]}]}`, string(filtered.Data))
+
+	other := deltaTestApp("other", "b", Healthy)
+	appService.update(deltaTestGroups(other), AppConfig{})
+	app := deltaTestApp("one", "a", Healthy)
+	appService.update(deltaTestGroups(other, app), AppConfig{})
+	added := readMessage(t, conn)
+	assert.Equal(t, deltaAppAdded, added.Type, "apps of other groups are left out")
+	assert.Equal(t, uint64(4), added.Seq)
+	assert.Contains(t, string(added.Data), `"id":"`+app.ID+`"`)
+
+	assert.NoError(t, conn.WriteJSON(WebsocketCommand{ID: "7", Type: commandSnapshot}))
+	assert.Equal(t, replyAck, readMessage(t, conn).Type)
+	snapshot := readMessage(t, conn)
+	assert.Equal(t, uint64(5), snapshot.Seq)
+	assert.Contains(t, string(snapshot.Data), app.ID)
+	assert.NotContains(t, string(snapshot.Data), other.ID)
+}
+
+func subscriberCount(broadcaster *Broadcaster) int {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	return len(broadcaster.subscribers)
+}
+
+func TestWebsocketServer_closedConnections(t *testing.T) {
+	server := newTestWebsocketServer(t)
+	for range 3 {
+		conn, _, err := websocket.DefaultDialer.Dial(server.url, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var message WebsocketMessage
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, broadcastSnapshot, message.Type)
+		closeSafe(conn)
+	}
+
+	assert.Eventually(t, func() bool { return subscriberCount(server.broadcaster) == 0 }, time.Second, 10*time.Millisecond,
+		"closed connections are removed without any write failing")
+	assert.Equal(t, uint64(3), server.lastId.Load())
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	assert.NoError(t, server.Shutdown(shutdownCtx), "the goroutines of closed connections are done")
+}
+
+func TestWebsocketServer_sessionEnded(t *testing.T) {
+	broadcaster, appService := newTestBroadcaster(t)
+	websocketServer := NewWebsocketServer(context.Background(), broadcaster, appService)
+	session, endSession := context.WithCancel(context.Background())
+	defer endSession()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		websocketServer.Connect(session, conn)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer closeSafe(conn)
+	assert.Equal(t, broadcastSnapshot, readMessage(t, conn).Type)
+
+	endSession()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "got %v", err)
+}
@@ -1,17 +1,27 @@
 export class AppHealthcheck {
+	link = '';
 	enabled = false;
 	health = 'unknown'; // TODO: make this an enum ?
 	interval = 0;
 	timeout = 0;
+	last_checked: string | null = null;
+	last_error = '';
+	uptime_24h: number | null = null;
+	uptime_7d: number | null = null;
+	latencies: number[] = [];
 }
 
 export class App {
+	id = '';
 	name = '';
 	link = '';
 	group = '';
 	description = '';
 	icon = '';
 	healthcheck = new AppHealthcheck();
+	tags: string[] = [];
+	provider = '';
+	stale = false;
 }
 
 export class AppGroup {
@@ -22,3 +32,11 @@ export class AppGroup {
 export class AppSettings {
 	name = 'simplydash';
 }
+
+export class AppDelta {
+	id = '';
+	group = '';
+	index = 0;
+	app?: App;
+	healthcheck?: AppHealthcheck;
+}
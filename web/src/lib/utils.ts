@@ -1,4 +1,5 @@
 import { dev } from '$app/environment';
+import type { AppDelta, AppGroup } from './models';
 
 const localDevHost = '192.168.1.90:8080';
 
@@ -14,3 +15,30 @@ export function websocketUrl(): string {
     const websocketProtocol = window.location.protocol === 'https:' ? 'wss' : 'ws';
     return `${websocketProtocol}://${host()}/ws`;
 }
+
+export function eventsUrl(): string {
+    return `${baseUrl()}/events`;
+}
+
+// applyDelta applies an app delta received on the websocket in place,
+// returning false if it doesn't match the app groups and a new snapshot is needed.
+export function applyDelta(appGroups: AppGroup[], type: string, delta: AppDelta): boolean {
+    const group = appGroups.find((group) => group.name === delta.group);
+    if (!group) {
+        return false;
+    }
+
+    const index = group.apps.findIndex((app) => app.id === delta.id);
+    if (type === 'app_added' && index < 0 && delta.app) {
+        group.apps.splice(Math.min(delta.index, group.apps.length), 0, delta.app);
+    } else if (type === 'app_removed' && index >= 0) {
+        group.apps.splice(index, 1);
+    } else if (type === 'app_updated' && index >= 0 && delta.app) {
+        group.apps[index] = delta.app;
+    } else if (type === 'health_changed' && index >= 0 && delta.healthcheck) {
+        group.apps[index] = { ...group.apps[index], healthcheck: delta.healthcheck };
+    } else {
+        return false;
+    }
+    return true;
+}